@@ -0,0 +1,352 @@
+package iso8583
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClientClosed = errors.New("client closed")
+var ErrDuplicateRequest = errors.New("duplicate request in flight")
+var ErrDisconnected = errors.New("disconnected")
+
+/*请求未发出(如连接失败),可以换一条链路重发*/
+var ErrNotSent = errors.New("request not sent")
+
+type notSentError struct {
+	err error
+}
+
+func (e notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e notSentError) Unwrap() []error {
+	return []error{ErrNotSent, e.err}
+}
+
+/*请求与应答的匹配键,请求与应答须得到相同的键*/
+type MatchKeyFunc func(iso *IsoEx) string
+
+/*报文类型第3位为奇数时是应答(0210、0230、0810...)*/
+func isResponseMTI(mti string) bool {
+	return len(mti) == 4 && (mti[2]-'0')%2 == 1
+}
+
+/*
+默认匹配键:请求报文类型(应答减10,第4位置0,重发的0201/0221与0210/0230对应) + 第11域流水号 + 第41域终端号.
+定长域按打包时的方式填充,请求中未补齐的值与解析出的应答值一致.
+*/
+func DefaultMatchKey(iso *IsoEx) string {
+	mti := iso.MTI()
+	if len(mti) == 4 {
+		class := mti[2]
+		if isResponseMTI(mti) {
+			class--
+		}
+		mti = mti[:2] + string(class) + "0"
+	}
+	return mti + "|" + string(iso.matchValue(11)) + "|" + string(iso.matchValue(41))
+}
+
+func (iso *IsoEx) matchValue(n int) []byte {
+	data := iso.GetField(n)
+	if data == nil || iso.checkFieldNo(n) != nil || iso.fdef[n-1].LenType != ISO_LEN_FIX {
+		return data
+	}
+	return iso.padValue(n-1, data)
+}
+
+type clientResult struct {
+	iso *IsoEx
+	err error
+}
+
+/*
+Client 与主机保持一个长连接,同一连接上可以有多个未完成的请求,
+应答按匹配键对应到请求;匹配不到的报文交给SetHandler设置的处理函数.
+连接断开时未完成的请求返回错误,下次发送时重新连接.
+*/
+type Client struct {
+	addr        string
+	proto       *IsoEx
+	framing     int16
+	tlsConfig   *tls.Config
+	matchKey    MatchKeyFunc
+	handler     func(*IsoEx)
+	ondrop      func(error)
+	dialTimeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	enc     *Encoder
+	pending map[string]chan clientResult
+	closed  bool
+
+	wmu sync.Mutex
+}
+
+/*proto提供报文的域定义与配置(包括报文头),应答报文由它复制*/
+func NewClient(addr string, proto *IsoEx, framing int16) *Client {
+	return &Client{
+		addr:        addr,
+		proto:       proto,
+		framing:     framing,
+		matchKey:    DefaultMatchKey,
+		dialTimeout: 10 * time.Second,
+		pending:     make(map[string]chan clientResult),
+	}
+}
+
+/*以下设置须在连接前调用*/
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+func (c *Client) SetMatchKey(f MatchKeyFunc) {
+	c.matchKey = f
+}
+
+/*主机主动发来的报文及超时后才到的应答*/
+func (c *Client) SetHandler(f func(*IsoEx)) {
+	c.handler = f
+}
+
+/*连接断开时调用,err为断开原因*/
+func (c *Client) SetDropHandler(f func(err error)) {
+	c.ondrop = f
+}
+
+func (c *Client) SetDialTimeout(d time.Duration) {
+	c.dialTimeout = d
+}
+
+func (c *Client) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connectLocked(ctx)
+}
+
+/*调用时持有c.mu,拨号期间释放,返回时重新持有;并发拨号时先建立的连接生效*/
+func (c *Client) connectLocked(ctx context.Context) error {
+	if c.closed {
+		return ErrClientClosed
+	}
+	if c.conn != nil {
+		return nil
+	}
+	c.mu.Unlock()
+	d := &net.Dialer{Timeout: c.dialTimeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: c.tlsConfig}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.addr)
+	}
+	c.mu.Lock()
+	if err != nil {
+		return err
+	}
+	if c.closed {
+		conn.Close()
+		return ErrClientClosed
+	}
+	if c.conn != nil {
+		conn.Close()
+		return nil
+	}
+	c.conn = conn
+	c.enc = NewEncoder(conn, c.framing)
+	go c.readLoop(conn)
+	return nil
+}
+
+func (c *Client) readLoop(conn net.Conn) {
+	dec := NewDecoder(conn, c.proto, c.framing)
+	for {
+		data, err := dec.ReadFrame()
+		if err != nil {
+			c.dropConn(conn, err)
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		iso := c.proto.Clone()
+		iso.Reset()
+		/*MAC错误的应答仍交给等待的请求,由请求返回错误*/
+		if err = iso.Str2IsoEx(data); err != nil && !errors.Is(err, ErrBadMAC) {
+			if l := c.proto.log(); l != nil {
+				l.Warn("discard unparsable message", slog.String("addr", c.addr), slog.Any("error", err))
+			}
+			continue
+		}
+		c.dispatch(iso, err)
+	}
+}
+
+func (c *Client) dispatch(iso *IsoEx, err error) {
+	if isResponseMTI(iso.MTI()) {
+		key := c.matchKey(iso)
+		c.mu.Lock()
+		ch, ok := c.pending[key]
+		if ok {
+			delete(c.pending, key)
+		}
+		c.mu.Unlock()
+		if ok {
+			ch <- clientResult{iso: iso, err: err}
+			return
+		}
+	}
+	if err != nil {
+		if l := c.proto.log(); l != nil {
+			l.Warn("discard message", slog.String("addr", c.addr), slog.String("mti", iso.MTI()), slog.Any("error", err))
+		}
+		return
+	}
+	if c.handler != nil {
+		go c.handler(iso)
+	} else if l := c.proto.log(); l != nil {
+		l.Warn("discard unsolicited message", slog.String("addr", c.addr), slog.String("mti", iso.MTI()))
+	}
+}
+
+/*关闭连接,未完成的请求返回err*/
+func (c *Client) dropConn(conn net.Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	c.enc = nil
+	if c.closed {
+		err = ErrClientClosed
+	}
+	for key, ch := range c.pending {
+		ch <- clientResult{err: err}
+		delete(c.pending, key)
+	}
+	if c.ondrop != nil {
+		go c.ondrop(err)
+	}
+}
+
+/*断开当前连接(未完成的请求返回ErrDisconnected),下次发送时重新连接*/
+func (c *Client) Disconnect() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.dropConn(conn, ErrDisconnected)
+	}
+}
+
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+func (c *Client) write(ctx context.Context, conn net.Conn, enc *Encoder, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	deadline, _ := ctx.Deadline()
+	conn.SetWriteDeadline(deadline)
+	return enc.WriteFrame(data)
+}
+
+/*发送请求并等待应答,ctx控制超时;超时后到达的应答交给处理函数.连接失败时errors.Is(err, ErrNotSent)*/
+func (c *Client) Send(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+	data, err := req.Iso2StrEx()
+	if err != nil {
+		return nil, err
+	}
+	key := c.matchKey(req)
+	ch := make(chan clientResult, 1)
+
+	c.mu.Lock()
+	if err = c.connectLocked(ctx); err != nil {
+		c.mu.Unlock()
+		return nil, notSentError{err}
+	}
+	if _, dup := c.pending[key]; dup {
+		c.mu.Unlock()
+		return nil, ErrDuplicateRequest
+	}
+	c.pending[key] = ch
+	conn, enc := c.conn, c.enc
+	c.mu.Unlock()
+
+	if err = c.write(ctx, conn, enc, data); err != nil {
+		c.removePending(key, ch)
+		c.dropConn(conn, err)
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		return r.iso, r.err
+	case <-ctx.Done():
+		c.removePending(key, ch)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) removePending(key string, ch chan clientResult) {
+	c.mu.Lock()
+	if c.pending[key] == ch {
+		delete(c.pending, key)
+	}
+	c.mu.Unlock()
+}
+
+/*发送不需要应答的报文,如通知或对主机请求的应答*/
+func (c *Client) Post(ctx context.Context, iso *IsoEx) error {
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if err = c.connectLocked(ctx); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	conn, enc := c.conn, c.enc
+	c.mu.Unlock()
+	if err = c.write(ctx, conn, enc, data); err != nil {
+		c.dropConn(conn, err)
+	}
+	return err
+}
+
+/*未关闭时可用,断开的连接在发送时重连*/
+func (c *Client) Up() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed
+}
+
+/*未完成的请求数*/
+func (c *Client) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.dropConn(conn, ErrClientClosed)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package iso8583
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMsg(mti, stan string) *IsoEx {
+	iso, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso.SetHeaderType(HEADER_TPDU)
+	iso.SetTPDU(TPDU{0x60, 0x0001, 0x0002})
+	iso.SetMTI(mti)
+	iso.SetField(11, []byte(stan))
+	iso.SetField(41, []byte("T0000001"))
+	return iso
+}
+
+/*测试主机:收齐n个请求后倒序应答,流水号999999不应答,并主动发一个0820*/
+func testHost(ln net.Listener, n int) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+	dec := NewDecoder(conn, proto, FRAME_BIN2)
+	enc := NewEncoder(conn, FRAME_BIN2)
+	var reqs []*IsoEx
+	for len(reqs) < n {
+		req, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		if string(req.GetField(11)) != "999999" {
+			reqs = append(reqs, req)
+		}
+	}
+	enc.Encode(newTestMsg("0820", "777777"))
+	for i := len(reqs) - 1; i >= 0; i-- {
+		rsp, _ := reqs[i].NewResponse()
+		rsp.SetField(11, reqs[i].GetField(11))
+		rsp.SetField(41, reqs[i].GetField(41))
+		rsp.SetField(39, []byte("00"))
+		enc.Encode(rsp)
+	}
+	for {
+		if _, err = dec.Decode(); err != nil {
+			return
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	const n = 8
+	go testHost(ln, n)
+
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+	c := NewClient(ln.Addr().String(), proto, FRAME_BIN2)
+	unsolicited := make(chan *IsoEx, 1)
+	c.SetHandler(func(iso *IsoEx) { unsolicited <- iso })
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.Send(ctx, newTestMsg("0200", "999999")); err != context.DeadlineExceeded {
+		t.Fatalf("timeout: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stan := fmt.Sprintf("%06d", i+1)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			rsp, err := c.Send(ctx, newTestMsg("0200", stan))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if rsp.MTI() != "0210" || string(rsp.GetField(11)) != stan || string(rsp.GetField(39)) != "00" {
+				errs <- fmt.Errorf("response %s", rsp)
+				return
+			}
+			if tpdu, _ := rsp.TPDU(); tpdu.Dest != 0x0002 {
+				errs <- fmt.Errorf("tpdu %s", tpdu)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	select {
+	case iso := <-unsolicited:
+		if iso.MTI() != "0820" {
+			t.Fatalf("unsolicited %s", iso.MTI())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no unsolicited message")
+	}
+	if c.InFlight() != 0 {
+		t.Fatalf("in flight %d", c.InFlight())
+	}
+
+	/*连接断开时未完成的请求返回错误*/
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Send(context.Background(), newTestMsg("0200", "000100"))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	if err = <-done; err != ErrClientClosed {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+/*重发的请求(0221)与应答(0230)对应*/
+func TestDefaultMatchKeyRepeat(t *testing.T) {
+	for _, tt := range []struct{ req, rsp string }{{"0200", "0210"}, {"0201", "0210"}, {"0221", "0230"}, {"0420", "0430"}} {
+		req := newTestMsg(tt.req, "000042")
+		rsp := newTestMsg(tt.rsp, "000042")
+		if DefaultMatchKey(req) != DefaultMatchKey(rsp) {
+			t.Errorf("%s key %q, %s key %q", tt.req, DefaultMatchKey(req), tt.rsp, DefaultMatchKey(rsp))
+		}
+	}
+	if DefaultMatchKey(newTestMsg("0221", "000042")) == DefaultMatchKey(newTestMsg("0210", "000042")) {
+		t.Error("0221 matched 0210")
+	}
+}
+
+func TestDefaultMatchKeyPadding(t *testing.T) {
+	req := newTestMsg("0200", "42")
+	req.SetField(41, []byte("T01"))
+	rsp, err := req.NewEchoResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rsp.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trim := range []bool{false, true} {
+		rx := newTestMsg("0800", "000000")
+		rx.Reset()
+		rx.SetTrimPad(trim)
+		if err = rx.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := DefaultMatchKey(rx), DefaultMatchKey(req); got != want {
+			t.Errorf("trim %v: response key %q, request key %q", trim, got, want)
+		}
+	}
+}
+
+/*拨号(TLS握手)期间不持有锁,其他调用不被阻塞;并发连接只保留一个*/
+func TestClientDialUnlocked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	c := NewClient(ln.Addr().String(), newTestMsg("0800", "000000"), FRAME_BIN2)
+	c.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Connect(ctx) }()
+	conn := <-accepted
+	defer conn.Close()
+
+	locked := make(chan bool, 1)
+	go func() { locked <- c.Connected() }()
+	select {
+	case up := <-locked:
+		if up {
+			t.Error("connected before handshake")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client locked while dialing")
+	}
+	cancel()
+	if err = <-done; err == nil {
+		t.Fatal("cancelled dial succeeded")
+	}
+
+	/*并发连接*/
+	c = NewClient(ln.Addr().String(), newTestMsg("0800", "000000"), FRAME_BIN2)
+	defer c.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Connect(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !c.Connected() {
+		t.Fatal("not connected")
+	}
+}
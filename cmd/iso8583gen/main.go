@@ -0,0 +1,86 @@
+/*
+iso8583gen 按报文规范生成带类型的Go消息结构体.
+
+	//go:generate iso8583gen -spec host.yaml -type HostMsg -o host_msg.go
+	//go:generate iso8583gen -table YL -type YLMsg -o yl_msg.go
+
+规范文件可以是JSON/YAML规范或jPOS GenericPackager的XML.
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	iso8583 "github.com/linphy/iso8583go"
+)
+
+/*内置的IsoExDef表*/
+var tables = map[string]*iso8583.Spec{
+	"YL": iso8583.SpecFromIsoExDef("YL", iso8583.BCDTYPE, iso8583.BCDTYPE, iso8583.BCDTYPE, iso8583.IsoExDefYL),
+}
+
+func main() {
+	specPath := flag.String("spec", "", "spec file (.json .yaml .yml .xml)")
+	table := flag.String("table", "", "built-in IsoExDef table (YL)")
+	typename := flag.String("type", "", "generated struct name (default: spec name)")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	output := flag.String("o", "", "output file (default: stdout)")
+	flag.Parse()
+
+	if err := run(*specPath, *table, *typename, *pkg, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "iso8583gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(specPath, table, typename, pkg, output string) error {
+	var spec *iso8583.Spec
+	var err error
+	switch {
+	case specPath != "" && table != "":
+		return fmt.Errorf("-spec and -table are exclusive")
+	case table != "":
+		if spec = tables[strings.ToUpper(table)]; spec == nil {
+			return fmt.Errorf("unknown table %q", table)
+		}
+	case strings.EqualFold(filepath.Ext(specPath), ".xml"):
+		var unsupported []string
+		if spec, unsupported, err = iso8583.LoadJPOS(specPath); err != nil {
+			return err
+		}
+		for _, s := range unsupported {
+			fmt.Fprintf(os.Stderr, "iso8583gen: %s: skipped %s\n", specPath, s)
+		}
+	case specPath != "":
+		if spec, err = iso8583.LoadSpec(specPath); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("-spec or -table is required")
+	}
+
+	if typename == "" {
+		typename = spec.Name
+	}
+	if typename == "" {
+		return fmt.Errorf("-type is required when the spec has no name")
+	}
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	var buf bytes.Buffer
+	if err = iso8583.GenerateGo(&buf, pkg, typename, spec); err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(output, buf.Bytes(), 0644)
+}
@@ -0,0 +1,146 @@
+package iso8583
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*敏感域脱敏,返回要显示的值;不需要处理的域原样返回*/
+type MaskFunc func(bitno int, value []byte) []byte
+
+/*
+DefaultMask 默认脱敏策略:主账号保留前4位和后4位,有效期、磁道、PIN及IC卡数据
+(第14、35、36、45、52、55域)全部替换为'*'.
+*/
+func DefaultMask(bitno int, value []byte) []byte {
+	switch bitno {
+	case 2:
+		if len(value) <= 8 {
+			return bytes.Repeat([]byte{'*'}, len(value))
+		}
+		masked := make([]byte, len(value))
+		copy(masked, value[:4])
+		for i := 4; i < len(value)-4; i++ {
+			masked[i] = '*'
+		}
+		copy(masked[len(value)-4:], value[len(value)-4:])
+		return masked
+	case 14, 35, 36, 45, 52, 55:
+		return bytes.Repeat([]byte{'*'}, len(value))
+	}
+	return value
+}
+
+/*不脱敏*/
+func NoMask(bitno int, value []byte) []byte {
+	return value
+}
+
+/*设置Describe/String使用的脱敏策略,nil为DefaultMask;日志的脱敏见SetLogMask*/
+func (iso *IsoEx) SetMask(mask MaskFunc) {
+	iso.mask = mask
+}
+
+func (iso *IsoEx) maskValue(bitno int, value []byte) []byte {
+	if iso.mask == nil {
+		return DefaultMask(bitno, value)
+	}
+	return iso.mask(bitno, value)
+}
+
+var dataEncLabels = map[int]string{
+	ISODASC: "ASCII",
+	ISODBCD: "BCD",
+	ISODBIN: "BINARY",
+	ISODC_D: "C_D",
+	ISODEBC: "EBCDIC",
+}
+
+var lenTypeLabels = []string{"FIXED", "LLVAR", "LLLVAR", "LLLLVAR"}
+
+/*域格式,如 LLVAR n..19 BCD、FIXED an8 ASCII*/
+func (d *IsoFieldDef) format() string {
+	var attr string
+	switch d.DataEnc {
+	case ISODBCD:
+		attr = "n"
+	case ISODBIN:
+		attr = "b"
+	case ISODC_D:
+		attr = "x+n"
+	default:
+		attr = "ans"
+	}
+	if d.LenType == ISO_LEN_FIX {
+		return fmt.Sprintf("%s %s%d %s", lenTypeLabels[d.LenType], attr, d.Length, dataEncLabels[d.DataEnc])
+	}
+	return fmt.Sprintf("%s %s..%d %s", lenTypeLabels[d.LenType], attr, d.Length, dataEncLabels[d.DataEnc])
+}
+
+/*域名取规范中的名称,没有时取标准名称*/
+func (iso *IsoEx) fieldName(bitno int) string {
+	if name := iso.fdef[bitno].Name; name != "" {
+		return name
+	}
+	if bitno < len(isoFieldNames) {
+		return isoFieldNames[bitno].name
+	}
+	return ""
+}
+
+/*
+Describe 输出可读的报文内容:报文类型、位图中的域号及各域的格式、名称和值,例如
+
+	F002 [LLVAR n..19 BCD] PAN: 6222********1234
+
+敏感域按SetMask设置的策略脱敏,二进制域及含不可打印字符的域以十六进制显示.
+*/
+func (iso *IsoEx) Describe(w io.Writer) error {
+	var b strings.Builder
+	if t, err := iso.TPDU(); err == nil {
+		fmt.Fprintf(&b, "TPDU: %s\n", t)
+	}
+	if h, err := iso.CUPHeader(); err == nil {
+		fmt.Fprintf(&b, "Header: %s\n", h)
+	} else if iso.headertype == 0 && iso.headerlen > 0 {
+		fmt.Fprintf(&b, "Header: %X\n", iso.header)
+	}
+	fmt.Fprintf(&b, "MTI: %s\n", iso.MTI())
+	var nums []string
+	for i := 1; i < len(iso.field); i++ {
+		if iso.field[i].bitflag != 0 {
+			nums = append(nums, fmt.Sprint(i+1))
+		}
+	}
+	fmt.Fprintf(&b, "Bitmap: %s\n", strings.Join(nums, " "))
+	for i := 1; i < len(iso.field); i++ {
+		if iso.field[i].bitflag == 0 {
+			continue
+		}
+		value := iso.field[i].data
+		if iso.fdef[i].DataEnc == ISODBIN || !isPrintable(value) {
+			value = []byte(fmt.Sprintf("%X", value))
+		}
+		value = iso.maskValue(i+1, value)
+		fmt.Fprintf(&b, "F%03d [%s] %s: %s\n", i+1, iso.fdef[i].format(), iso.fieldName(i), value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (iso *IsoEx) String() string {
+	var b strings.Builder
+	iso.Describe(&b)
+	return b.String()
+}
+
+func isPrintable(data []byte) bool {
+	for _, ch := range data {
+		if ch < 0x20 || ch > 0x7e {
+			return false
+		}
+	}
+	return true
+}
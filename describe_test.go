@@ -0,0 +1,41 @@
+package iso8583
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	iso, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(4, []byte("000000000100"))
+	iso.SetField(35, []byte("6222020000001234=2512"))
+	iso.SetField(41, []byte("T01     "))
+	iso.SetField(52, []byte{0x12, 0x34, 0xab, 0xcd, 0, 0, 0, 0xff})
+
+	expect := `MTI: 0200
+Bitmap: 2 4 35 41 52
+F002 [LLVAR n..19 BCD] PAN: 6222********1234
+F004 [FIXED n12 BCD] Amount: 000000000100
+F035 [LLVAR n..37 BCD] Track2: *********************
+F041 [FIXED ans8 ASCII] TerminalID: T01     
+F052 [FIXED b64 BINARY] PINData: ****************
+`
+	if iso.String() != expect {
+		t.Fatalf("String\n%s", iso.String())
+	}
+
+	iso.SetMask(NoMask)
+	if !strings.Contains(iso.String(), "PAN: 6222020000001234\n") || !strings.Contains(iso.String(), "PINData: 1234ABCD000000FF\n") {
+		t.Fatalf("NoMask\n%s", iso)
+	}
+	spec := SpecFromIsoExDef("YL", BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	spec.Fields[40].Name = "Terminal"
+	iso2, _ := NewIsoExSpec(spec)
+	iso2.SetMTI("0800")
+	iso2.SetField(41, []byte("T01"))
+	if !strings.Contains(iso2.String(), "F041 [FIXED ans8 ASCII] Terminal: T01\n") {
+		t.Fatalf("spec name\n%s", iso2)
+	}
+}
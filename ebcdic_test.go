@@ -0,0 +1,76 @@
+package iso8583
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEbcdic(t *testing.T) {
+	asc := []byte("0200 ABCxyz[]^!")
+	e037 := Asc2Ebcdic(asc, CP037)
+	e1047 := Asc2Ebcdic(asc, CP1047)
+	if bytes.Compare(e037[:4], []byte{0xf0, 0xf2, 0xf0, 0xf0}) != 0 {
+		t.Fatalf("CP037 digits [% x]", e037[:4])
+	}
+	if e037[11] != 0xba || e1047[11] != 0xad || e037[13] != 0xb0 || e1047[13] != 0x5f {
+		t.Fatalf("code page [% x] [% x]", e037, e1047)
+	}
+	if bytes.Compare(Ebcdic2Asc(e037, CP037), asc) != 0 || bytes.Compare(Ebcdic2Asc(e1047, CP1047), asc) != 0 {
+		t.Fatal("Ebcdic2Asc failed")
+	}
+	for i := 0; i < 256; i++ {
+		b := []byte{byte(i)}
+		if bytes.Compare(Ebcdic2Asc(Asc2Ebcdic(b, CP1047), CP1047), b) != 0 {
+			t.Fatalf("CP1047 round trip %02x", i)
+		}
+	}
+}
+
+func TestStr2IsoExEbcdic(t *testing.T) {
+	def := append([]IsoExDef{}, IsoExDefYL...)
+	def[36] = IsoExDef{12, ISOLFIX | ISODEBC | ISOFSP | ISOLJUST}
+	def[40] = IsoExDef{8, ISOLFIX | ISODEBC | ISOFSP | ISOLJUST}
+	def[47] = IsoExDef{62, ISOLV3 | ISODEBC | ISOFSP | ISOLJUST}
+
+	iso, _ := NewIsoEx(EBCDICTYPE, BCDTYPE, EBCDICTYPE, def)
+	if err := iso.SetCodePage(500); err == nil {
+		t.Fatal("SetCodePage accept 500")
+	}
+	iso.SetCodePage(CP1047)
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(37, []byte("REF[1]"))
+	iso.SetField(41, []byte("TERM0001"))
+	iso.SetField(48, []byte("ebcdic ^ data"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data[:4], []byte{0xf0, 0xf2, 0xf0, 0xf0}) != 0 {
+		t.Fatalf("mti [% x]", data[:4])
+	}
+	/*第2域EBCDIC长度前缀"16"*/
+	if bytes.Compare(data[12:14], []byte{0xf1, 0xf6}) != 0 {
+		t.Fatalf("LL [% x]", data[12:14])
+	}
+	if bytes.Index(data, Asc2Ebcdic([]byte("REF[1]      TERM0001"), CP1047)) < 0 {
+		t.Fatalf("ebcdic data [% x]", data)
+	}
+	if bytes.Index(data, []byte{0xf0, 0xf1, 0xf3}) < 0 {
+		t.Fatalf("LLL [% x]", data)
+	}
+
+	iso2, _ := NewIsoEx(EBCDICTYPE, BCDTYPE, EBCDICTYPE, def)
+	iso2.SetCodePage(CP1047)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if iso2.MTI() != "0200" || string(iso2.GetField(2)) != "6222020000001234" ||
+		string(iso2.GetField(37)) != "REF[1]      " || string(iso2.GetField(48)) != "ebcdic ^ data" {
+		t.Fatal("Str2IsoEx ebcdic failed")
+	}
+	data2, _ := iso2.Iso2StrEx()
+	if bytes.Compare(data, data2) != 0 {
+		t.Fatal("Compare data and data2 failed")
+	}
+}
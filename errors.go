@@ -0,0 +1,55 @@
+package iso8583
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrShortBuffer  = errors.New("short buffer")
+	ErrBadLength    = errors.New("bad length digits")
+	ErrBadBCD       = errors.New("bad bcd nibble")
+	ErrBadBitmap    = errors.New("bad bitmap")
+	ErrFieldTooLong = errors.New("exceeds max length")
+	ErrNoFieldDef   = errors.New("field not defined")
+	ErrBadMAC       = errors.New("mac mismatch")
+	ErrMACPosition  = errors.New("mac field not in last bitmap position")
+)
+
+/*
+ParseError 报文解析错误.
+Field为ISO域号(0为报文类型,1为位图,-1为报文头),Offset为出错时在报文中的字节偏移,
+Expected/Available为期望与实际可用的长度,Err为具体原因.
+*/
+type ParseError struct {
+	Field     int
+	Offset    int
+	Expected  int
+	Available int
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	var name string
+	switch e.Field {
+	case -1:
+		name = "header"
+	case 0:
+		name = "mti"
+	case 1:
+		name = "bitmap"
+	default:
+		name = fmt.Sprintf("field %d", e.Field)
+	}
+	if e.Err == ErrShortBuffer {
+		return fmt.Sprintf("iso8583: %s at offset %d: need %d bytes, have %d: %v", name, e.Offset, e.Expected, e.Available, e.Err)
+	}
+	if e.Err == ErrFieldTooLong {
+		return fmt.Sprintf("iso8583: %s at offset %d: length %d %v %d", name, e.Offset, e.Available, e.Err, e.Expected)
+	}
+	return fmt.Sprintf("iso8583: %s at offset %d: %v", name, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
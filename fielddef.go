@@ -0,0 +1,170 @@
+package iso8583
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*IsoFieldDef.LenEnc未设置(零值且LenEncSet为false)时使用报文的lentype*/
+const LENDEFAULT = 0
+
+/*
+IsoFieldDef 扩展域定义,每个域可单独指定长度前缀编码、数据编码、填充字符.
+Length为最大长度:BCD域为数字个数,ASC/EBCDIC域为字符数,借贷记域不含'C'/'D',
+二进制域为位数,为0表示该域未定义;LenInBytes为true时BCD及二进制域的长度(含长度前缀)按字节计.
+LenEnc与Pad不为0时直接使用;为0时只有LenEncSet/PadSet为true才生效(BCD长度前缀、0x00填充),否则取默认值:
+长度前缀用报文的lentype;二进制域填0x00,BCD、借贷记及右对齐的域填'0',其他填空格.
+Type为生成代码时的Go类型:"string" "int" "bytes" "time:<layout>",为空时按域号与编码推断.
+*/
+type IsoFieldDef struct {
+	Name       string
+	Desc       string
+	Length     int
+	LenType    int   /*ISO_LEN_FIX ISO_LEN_VAR2 ISO_LEN_VAR3 ISO_LEN_VAR4*/
+	LenEnc     int16 /*BCDTYPE ASCTYPE HEXTYPE EBCDICTYPE BINTYPE*/
+	LenEncSet  bool  /*LenEnc为BCDTYPE(0)时须设置,否则为默认*/
+	DataEnc    int   /*ISODASC ISODBCD ISODBIN ISODC_D ISODEBC*/
+	Pad        byte  /*定长域填充字符,二进制域为填充字节*/
+	PadSet     bool  /*Pad为0x00时须设置,否则为默认*/
+	RightJust  bool
+	LenInBytes bool
+	Type       string
+}
+
+/*按原有的def标志位转换为扩展域定义*/
+func (d IsoExDef) FieldDef() IsoFieldDef {
+	fd := IsoFieldDef{
+		Length:    int(d.length),
+		LenType:   int(d.def >> 6),
+		DataEnc:   int(d.def & ISO_DATA_MASK),
+		PadSet:    true,
+		RightJust: d.def&ISO_JUST_MASK == ISORJUST,
+	}
+	switch {
+	case fd.DataEnc == ISODBIN:
+		fd.Pad = 0x00
+	case fd.DataEnc == ISODBCD && d.def&ISO_FIL_MASK == ISOFSP:
+		fd.Pad = 'F'
+	case d.def&ISO_FIL_MASK == ISOFSP:
+		fd.Pad = ' '
+	default:
+		fd.Pad = '0'
+	}
+	return fd
+}
+
+func ConvertIsoExDef(isodef []IsoExDef) []IsoFieldDef {
+	fielddef := make([]IsoFieldDef, len(isodef))
+	for i, d := range isodef {
+		fielddef[i] = d.FieldDef()
+	}
+	return fielddef
+}
+
+func (d *IsoFieldDef) check() error {
+	if d.Length < 0 {
+		return fmt.Errorf("invalid length %d", d.Length)
+	}
+	if d.LenType < ISO_LEN_FIX || d.LenType > ISO_LEN_VAR4 {
+		return fmt.Errorf("invalid length type %d", d.LenType)
+	}
+	if d.LenEnc < BCDTYPE || d.LenEnc > BINTYPE {
+		return fmt.Errorf("invalid length encoding %d", d.LenEnc)
+	}
+	switch d.DataEnc {
+	case ISODASC, ISODBCD, ISODBIN, ISODC_D, ISODEBC:
+	default:
+		return fmt.Errorf("invalid data encoding %#x", d.DataEnc)
+	}
+	switch {
+	case d.Type == "", d.Type == "string", d.Type == "int", d.Type == "bytes":
+	case strings.HasPrefix(d.Type, "time:") && len(d.Type) > 5:
+	default:
+		return fmt.Errorf("invalid type %q", d.Type)
+	}
+	return nil
+}
+
+/*是否单独指定了长度前缀编码*/
+func (d *IsoFieldDef) hasLenEnc() bool {
+	return d.LenEncSet || d.LenEnc != LENDEFAULT
+}
+
+/*长度前缀编码,未设置时为dflt*/
+func (d *IsoFieldDef) lenEnc(dflt int16) int16 {
+	if d.hasLenEnc() {
+		return d.LenEnc
+	}
+	return dflt
+}
+
+func (d *IsoFieldDef) hasPad() bool {
+	return d.PadSet || d.Pad != 0
+}
+
+/*填充字符,未设置时按编码取默认值*/
+func (d *IsoFieldDef) padByte() byte {
+	switch {
+	case d.hasPad() || d.DataEnc == ISODBIN:
+		return d.Pad
+	case d.RightJust || d.DataEnc == ISODBCD || d.DataEnc == ISODC_D:
+		return '0'
+	}
+	return ' '
+}
+
+/*长度为0的域视为未定义*/
+func (d *IsoFieldDef) defined() bool {
+	return d.Length > 0
+}
+
+/*长度单位数对应的域值(解包后)字节数*/
+func (d *IsoFieldDef) valueLen(units int) int {
+	switch d.DataEnc {
+	case ISODBCD:
+		if d.LenInBytes {
+			return units * 2
+		}
+	case ISODBIN:
+		if !d.LenInBytes {
+			return units / 8
+		}
+	case ISODC_D:
+		return units + 1
+	}
+	return units
+}
+
+/*长度单位数对应的报文字节数*/
+func (d *IsoFieldDef) wireLen(units int) int {
+	switch d.DataEnc {
+	case ISODBCD:
+		if !d.LenInBytes {
+			return (units + 1) / 2
+		}
+	case ISODBIN:
+		if !d.LenInBytes {
+			return units / 8
+		}
+	case ISODC_D:
+		return units + 1
+	}
+	return units
+}
+
+/*域值字节数对应的长度单位数,用于打包长度前缀*/
+func (d *IsoFieldDef) units(value_len int) int {
+	switch d.DataEnc {
+	case ISODBCD:
+		if d.LenInBytes {
+			return (value_len + 1) / 2
+		}
+	case ISODBIN:
+		if !d.LenInBytes {
+			return value_len * 8
+		}
+	case ISODC_D:
+		return value_len - 1
+	}
+	return value_len
+}
@@ -0,0 +1,105 @@
+package iso8583
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFieldDef(t *testing.T) {
+	fd := IsoExDefYL[34].FieldDef()
+	if fd.Length != 37 || fd.LenType != ISO_LEN_VAR2 || fd.LenEnc != LENDEFAULT || fd.DataEnc != ISODBCD || fd.Pad != '0' || fd.RightJust {
+		t.Fatalf("FieldDef %+v", fd)
+	}
+	fd = IsoExDefYL[36].FieldDef()
+	if fd.Pad != ' ' || fd.LenType != ISO_LEN_FIX || fd.DataEnc != ISODASC {
+		t.Fatalf("FieldDef %+v", fd)
+	}
+
+	fielddef := ConvertIsoExDef(IsoExDefYL)
+	fielddef[5].LenType = 7
+	if _, err := NewIsoExFields(0, 0, 0, fielddef); err == nil {
+		t.Fatal("NewIsoExFields accept invalid length type")
+	}
+}
+
+func TestNewIsoExFields(t *testing.T) {
+	fielddef := ConvertIsoExDef(IsoExDefYL)
+	/*第32域ASCII长度、按字节计长的BCD*/
+	fielddef[31] = IsoFieldDef{Length: 6, LenType: ISO_LEN_VAR2, LenEnc: ASCTYPE, DataEnc: ISODBCD, LenInBytes: true}
+	/*第41域EBCDIC数据,以'*'右对齐填充*/
+	fielddef[40] = IsoFieldDef{Length: 8, LenType: ISO_LEN_FIX, LenEnc: LENDEFAULT, DataEnc: ISODEBC, Pad: '*', RightJust: true}
+	/*第48域二进制长度,按字节计长的二进制数据*/
+	fielddef[47] = IsoFieldDef{Length: 32, LenType: ISO_LEN_VAR3, LenEnc: BINTYPE, DataEnc: ISODBIN, LenInBytes: true}
+
+	iso, err := NewIsoExFields(BCDTYPE, BCDTYPE, BCDTYPE, fielddef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(32, []byte("12345678901"))
+	iso.SetField(41, []byte("T1"))
+	iso.SetField(48, []byte{0xde, 0xad, 0xbe, 0xef})
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{0x02, 0x00, 0x40, 0x00, 0x00, 0x01, 0x00, 0x81, 0x00, 0x00}
+	expect = append(expect, 0x16, 0x62, 0x22, 0x02, 0x00, 0x00, 0x00, 0x12, 0x34)
+	expect = append(expect, '0', '6', 0x12, 0x34, 0x56, 0x78, 0x90, 0x10)
+	expect = append(expect, Asc2Ebcdic([]byte("******T1"), CP037)...)
+	expect = append(expect, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef)
+	if bytes.Compare(data, expect) != 0 {
+		t.Fatalf("Iso2StrEx [% x]", data)
+	}
+
+	iso2, _ := NewIsoExFields(BCDTYPE, BCDTYPE, BCDTYPE, fielddef)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if string(iso2.GetField(32)) != "123456789010" || string(iso2.GetField(41)) != "******T1" ||
+		bytes.Compare(iso2.GetField(48), []byte{0xde, 0xad, 0xbe, 0xef}) != 0 {
+		t.Fatal("Str2IsoEx failed")
+	}
+
+	/*SetFieldLenType不影响调用者的定义表*/
+	iso2.SetFieldLenType(2, ASCTYPE)
+	if fielddef[1].LenEnc != LENDEFAULT {
+		t.Fatal("SetFieldLenType changed caller's definition")
+	}
+}
+
+/*LenEnc与Pad的零值使用默认值,LenEncSet/PadSet指定BCD长度前缀与0x00填充*/
+func TestFieldDefZeroValue(t *testing.T) {
+	fielddef := make([]IsoFieldDef, 64)
+	fielddef[0] = IsoExDefYL[0].FieldDef()
+	fielddef[2] = IsoFieldDef{Length: 19, LenType: ISO_LEN_VAR2, DataEnc: ISODASC}
+	fielddef[3] = IsoFieldDef{Length: 12, LenType: ISO_LEN_FIX, DataEnc: ISODASC, RightJust: true}
+	fielddef[40] = IsoFieldDef{Length: 8, LenType: ISO_LEN_FIX, DataEnc: ISODASC}
+	fielddef[43] = IsoFieldDef{Length: 25, LenType: ISO_LEN_VAR2, LenEnc: BCDTYPE, LenEncSet: true, DataEnc: ISODASC}
+	fielddef[44] = IsoFieldDef{Length: 4, LenType: ISO_LEN_FIX, DataEnc: ISODASC, PadSet: true}
+
+	iso, err := NewIsoExFields(ASCTYPE, ASCTYPE, ASCTYPE, fielddef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso.SetMTI("0200")
+	iso.SetField(3, []byte("123"))
+	iso.SetField(4, []byte("100"))
+	iso.SetField(41, []byte("T1"))
+	iso.SetField(44, []byte("ab"))
+	iso.SetField(45, []byte("x"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "0200" + "3000000000980000" + "03123" + "000000000100" + "T1      " + "\x02ab" + "x\x00\x00\x00"
+	if string(data) != expect {
+		t.Fatalf("Iso2StrEx [%q]", data)
+	}
+	iso.SetFieldLenType(3, BCDTYPE)
+	if iso.fdef[2].lenEnc(ASCTYPE) != BCDTYPE {
+		t.Errorf("SetFieldLenType bcd %d", iso.fdef[2].LenEnc)
+	}
+}
@@ -0,0 +1,213 @@
+package iso8583
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const importPath = "github.com/linphy/iso8583go"
+
+/*标准中的日期时间域及其格式*/
+var isoTimeLayouts = map[int]string{
+	7:  "0102150405",
+	12: "150405",
+	13: "0102",
+	14: "0601",
+	15: "0102",
+	16: "0102",
+	17: "0102",
+}
+
+/*域的Go类型,Type为空时:二进制域为bytes,金额与流水号为int,标准日期时间域为time*/
+func (d *IsoFieldDef) goType(bitno int) string {
+	if d.Type != "" {
+		return d.Type
+	}
+	switch {
+	case d.DataEnc == ISODBIN:
+		return "bytes"
+	case d.DataEnc == ISODC_D:
+		return "string"
+	}
+	if d.LenType == ISO_LEN_FIX {
+		switch bitno {
+		case 4, 5, 6, 8, 11:
+			if d.Length <= 18 {
+				return "int"
+			}
+		}
+		if layout, ok := isoTimeLayouts[bitno]; ok && len(layout) == d.valueLen(d.Length) {
+			return "time:" + layout
+		}
+	}
+	return "string"
+}
+
+/*域名转换为导出的Go标识符,无名称时为FieldNNN*/
+func goFieldName(name string, bitno int) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || !unicode.IsLetter([]rune(s)[0]) {
+		return fmt.Sprintf("Field%03d%s", bitno, s)
+	}
+	return s
+}
+
+type genField struct {
+	no     int
+	name   string
+	typ    string
+	desc   string
+	trim   bool
+	layout string
+}
+
+/*
+GenerateGo 按规范生成Go代码:规范变量<typename>Spec、消息结构体typename
+及其Marshal/Unmarshal方法.整数域为指针,nil表示没有该域,0可以打包;
+其他类型的域为零值时不打包.
+*/
+func GenerateGo(w io.Writer, pkg, typename string, spec *Spec) error {
+	if !token.IsIdentifier(typename) || !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid identifier %q/%q", pkg, typename)
+	}
+	if _, err := NewIsoExSpec(spec); err != nil {
+		return err
+	}
+
+	var fields []genField
+	names := map[string]int{"MTI": 0}
+	need := map[string]bool{}
+	for i := 1; i < len(spec.Fields); i++ {
+		def := &spec.Fields[i]
+		if !def.defined() || (i == 64 && len(spec.Fields) > 128) {
+			continue
+		}
+		gf := genField{no: i + 1, name: goFieldName(def.Name, i+1), desc: def.Desc}
+		if gf.desc == "" {
+			gf.desc = def.Name
+		}
+		if _, dup := names[gf.name]; dup {
+			gf.name = fmt.Sprintf("%s%d", gf.name, i+1)
+		}
+		names[gf.name] = i + 1
+
+		hint := def.goType(i + 1)
+		switch {
+		case hint == "string":
+			gf.typ = "string"
+			gf.trim = def.padByte() == ' ' && !def.RightJust && def.LenType == ISO_LEN_FIX
+			need["strings"] = gf.trim || need["strings"]
+		case hint == "bytes":
+			gf.typ = "[]byte"
+		case hint == "int":
+			if def.DataEnc == ISODBIN || def.DataEnc == ISODC_D {
+				return fmt.Errorf("field %d: type int needs numeric encoding", i+1)
+			}
+			gf.typ = "*int64"
+			need["strconv"], need["strings"], need["fmt"] = true, true, true
+		case strings.HasPrefix(hint, "time:"):
+			if def.DataEnc == ISODBIN {
+				return fmt.Errorf("field %d: type %s needs character encoding", i+1, hint)
+			}
+			gf.typ = "time.Time"
+			gf.layout = hint[5:]
+			need["time"], need["fmt"] = true, true
+		default:
+			return fmt.Errorf("field %d: invalid type %q", i+1, hint)
+		}
+		fields = append(fields, gf)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by iso8583gen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	for _, imp := range []string{"fmt", "strconv", "strings", "time"} {
+		if need[imp] {
+			fmt.Fprintf(&b, "%q\n", imp)
+		}
+	}
+	fmt.Fprintf(&b, "\niso8583 %q\n)\n\n", importPath)
+
+	fmt.Fprintf(&b, "var %sSpec = &iso8583.Spec{\nName: %q,\nMsgType: %d,\nBitType: %d,\nLenType: %d,\nCodePage: %d,\nFields: []iso8583.IsoFieldDef{\n",
+		typename, spec.Name, spec.MsgType, spec.BitType, spec.LenType, spec.CodePage)
+	for i := range spec.Fields {
+		fmt.Fprintf(&b, "%#v, // %d\n", spec.Fields[i], i+1)
+	}
+	fmt.Fprintf(&b, "},\n}\n\n")
+
+	fmt.Fprintf(&b, "type %s struct {\nMTI string\n", typename)
+	for _, gf := range fields {
+		fmt.Fprintf(&b, "%s %s // %d %s\n", gf.name, gf.typ, gf.no, gf.desc)
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "func (m *%s) Marshal() ([]byte, error) {\n", typename)
+	fmt.Fprintf(&b, "iso, err := iso8583.NewIsoExSpec(%sSpec)\nif err != nil {\nreturn nil, err\n}\n", typename)
+	fmt.Fprintf(&b, "if err = iso.SetMTI(m.MTI); err != nil {\nreturn nil, err\n}\n")
+	for _, gf := range fields {
+		var cond, value string
+		switch gf.typ {
+		case "string":
+			cond, value = "m."+gf.name+` != ""`, "[]byte(m."+gf.name+")"
+		case "[]byte":
+			cond, value = "m."+gf.name+" != nil", "m."+gf.name
+		case "*int64":
+			cond, value = "m."+gf.name+" != nil", "[]byte(strconv.FormatInt(*m."+gf.name+", 10))"
+		case "time.Time":
+			cond, value = "!m."+gf.name+".IsZero()", "[]byte(m."+gf.name+".Format("+strconv.Quote(gf.layout)+"))"
+		}
+		fmt.Fprintf(&b, "if %s {\nif err = iso.SetField(%d, %s); err != nil {\nreturn nil, err\n}\n}\n", cond, gf.no, value)
+	}
+	fmt.Fprintf(&b, "return iso.Iso2StrEx()\n}\n\n")
+
+	fmt.Fprintf(&b, "func (m *%s) Unmarshal(data []byte) error {\n", typename)
+	fmt.Fprintf(&b, "iso, err := iso8583.NewIsoExSpec(%sSpec)\nif err != nil {\nreturn err\n}\n", typename)
+	fmt.Fprintf(&b, "if err = iso.Str2IsoEx(data); err != nil {\nreturn err\n}\n*m = %s{MTI: iso.MTI()}\n", typename)
+	if len(fields) > 0 {
+		fmt.Fprintf(&b, "var v []byte\n")
+	}
+	for _, gf := range fields {
+		fmt.Fprintf(&b, "if v = iso.GetField(%d); v != nil {\n", gf.no)
+		switch gf.typ {
+		case "string":
+			if gf.trim {
+				fmt.Fprintf(&b, "m.%s = strings.TrimRight(string(v), \" \")\n", gf.name)
+			} else {
+				fmt.Fprintf(&b, "m.%s = string(v)\n", gf.name)
+			}
+		case "[]byte":
+			fmt.Fprintf(&b, "m.%s = v\n", gf.name)
+		case "*int64":
+			fmt.Fprintf(&b, "n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64)\nif err != nil {\nreturn fmt.Errorf(\"field %d: %%v\", err)\n}\nm.%s = &n\n", gf.no, gf.name)
+		case "time.Time":
+			fmt.Fprintf(&b, "if m.%s, err = time.Parse(%q, string(v)); err != nil {\nreturn fmt.Errorf(\"field %d: %%v\", err)\n}\n", gf.name, gf.layout, gf.no)
+		}
+		fmt.Fprintf(&b, "}\n")
+	}
+	fmt.Fprintf(&b, "return nil\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("generate: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,127 @@
+package iso8583
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGo(t *testing.T) {
+	spec := SpecFromIsoExDef("YL", BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	spec.Fields[12].Type = "time:0102"
+	spec.Fields[40].Type = "string"
+	spec.Fields[48].Type = "int"
+
+	var buf bytes.Buffer
+	if err := GenerateGo(&buf, "msg", "YLMsg", spec); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.String()
+	if _, err := parser.ParseFile(token.NewFileSet(), "yl.go", src, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"var YLMsgSpec = &iso8583.Spec{",
+		"PAN                      string",
+		"Amount                   *int64",
+		"TransmissionDateTime     time.Time",
+		"TransactionCurrencyCode  *int64",
+		"MAC                      []byte",
+		`m.TerminalID = strings.TrimRight(string(v), " ")`,
+		`m.LocalDate, err = time.Parse("0102", string(v))`,
+		"if err = iso.SetField(128, m.MAC2); err != nil {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Fatalf("generated code missing %q", s)
+		}
+	}
+
+	spec.Fields[51].Type = "int"
+	if err := GenerateGo(&buf, "msg", "YLMsg", spec); err == nil {
+		t.Fatal("GenerateGo accept int binary field")
+	}
+	if err := GenerateGo(&buf, "msg", "YL-Msg", spec); err == nil {
+		t.Fatal("GenerateGo accept bad type name")
+	}
+}
+
+/*生成的代码与程序放在临时模块中编译运行,Amount为0也须打包并解出*/
+const genRoundTrip = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	zero := int64(0)
+	in := YLMsg{
+		MTI:        "0200",
+		PAN:        "6222021234567890123",
+		Amount:     &zero,
+		LocalDate:  time.Date(0, 12, 31, 0, 0, 0, 0, time.UTC),
+		TerminalID: "T01",
+		MAC:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	data, err := in.Marshal()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var out YLMsg
+	if err = out.Unmarshal(data); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if out.MTI != in.MTI || out.PAN != in.PAN || out.Amount == nil || *out.Amount != 0 ||
+		!out.LocalDate.Equal(in.LocalDate) || out.TerminalID != "T01" || !bytes.Equal(out.MAC, in.MAC) ||
+		out.STAN != nil {
+		fmt.Printf("round trip: %+v\n", out)
+		os.Exit(1)
+	}
+	fmt.Print("ok")
+}
+`
+
+func TestGenerateGoCompile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("go build in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := SpecFromIsoExDef("YL", BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	spec.Fields[12].Type = "time:0102"
+	var buf bytes.Buffer
+	if err = GenerateGo(&buf, "main", "YLMsg", spec); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	gomod := "module gentest\n\ngo 1.21\n\nrequire " + importPath + " v0.0.0\n\nreplace " + importPath + " => " + root + "\n"
+	files := map[string]string{"go.mod": gomod, "yl_msg.go": buf.String(), "main.go": genRoundTrip}
+	for name, content := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GO111MODULE=on")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "ok" {
+		t.Fatalf("generated code: %v\n%s", err, out)
+	}
+}
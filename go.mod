@@ -0,0 +1,3 @@
+module github.com/linphy/iso8583go
+
+go 1.21
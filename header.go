@@ -0,0 +1,148 @@
+package iso8583
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*报文头类型,可组合;TPDU在前*/
+const HEADER_TPDU = 0x01 /*5字节TPDU*/
+const HEADER_CUP = 0x02  /*6字节银联POS报文头*/
+
+const TPDU_LEN = 5
+const CUP_HEADER_LEN = 6
+
+/*TPDU:ID(通常为0x60)、目的地址、源地址*/
+type TPDU struct {
+	ID   byte
+	Dest uint16
+	Src  uint16
+}
+
+func (t TPDU) Bytes() []byte {
+	return []byte{t.ID, byte(t.Dest >> 8), byte(t.Dest), byte(t.Src >> 8), byte(t.Src)}
+}
+
+func ParseTPDU(data []byte) (TPDU, error) {
+	if len(data) < TPDU_LEN {
+		return TPDU{}, ErrShortBuffer
+	}
+	return TPDU{ID: data[0], Dest: uint16(data[1])<<8 | uint16(data[2]), Src: uint16(data[3])<<8 | uint16(data[4])}, nil
+}
+
+func (t TPDU) String() string {
+	return fmt.Sprintf("%02X %04X %04X", t.ID, t.Dest, t.Src)
+}
+
+/*银联POS报文头:应用类别、软件总版本号、终端状态(高4位)与处理要求(低4位)、软件分版本号*/
+type CUPHeader struct {
+	AppType    byte
+	Version    byte
+	Status     byte
+	Process    byte
+	SubVersion [3]byte
+}
+
+func (h CUPHeader) Bytes() []byte {
+	return []byte{h.AppType, h.Version, h.Status<<4 | h.Process&0x0f, h.SubVersion[0], h.SubVersion[1], h.SubVersion[2]}
+}
+
+func ParseCUPHeader(data []byte) (CUPHeader, error) {
+	if len(data) < CUP_HEADER_LEN {
+		return CUPHeader{}, ErrShortBuffer
+	}
+	h := CUPHeader{AppType: data[0], Version: data[1], Status: data[2] >> 4, Process: data[2] & 0x0f}
+	copy(h.SubVersion[:], data[3:6])
+	return h, nil
+}
+
+func (h CUPHeader) String() string {
+	return fmt.Sprintf("%02X %02X %X %X %X", h.AppType, h.Version, h.Status, h.Process, h.SubVersion[:])
+}
+
+/*设置报文头类型,报文头长度随之确定*/
+func (iso *IsoEx) SetHeaderType(flags int) error {
+	if flags&^(HEADER_TPDU|HEADER_CUP) != 0 {
+		return fmt.Errorf("unsupported header type %#x", flags)
+	}
+	n := 0
+	if flags&HEADER_TPDU != 0 {
+		n += TPDU_LEN
+	}
+	if flags&HEADER_CUP != 0 {
+		n += CUP_HEADER_LEN
+	}
+	iso.SetHeaderLen(n)
+	iso.headertype = flags
+	return nil
+}
+
+/*未设置报文头时生成全0的报文头*/
+func (iso *IsoEx) headerBytes() []byte {
+	if iso.header == nil {
+		return make([]byte, iso.headerlen)
+	}
+	return iso.header
+}
+
+func (iso *IsoEx) TPDU() (TPDU, error) {
+	if iso.headertype&HEADER_TPDU == 0 {
+		return TPDU{}, errors.New("no tpdu header")
+	}
+	return ParseTPDU(iso.headerBytes())
+}
+
+func (iso *IsoEx) SetTPDU(t TPDU) error {
+	if iso.headertype&HEADER_TPDU == 0 {
+		return errors.New("no tpdu header")
+	}
+	header := append([]byte(nil), iso.headerBytes()...)
+	copy(header, t.Bytes())
+	iso.header = header
+	return nil
+}
+
+func (iso *IsoEx) cupOffset() int {
+	if iso.headertype&HEADER_TPDU != 0 {
+		return TPDU_LEN
+	}
+	return 0
+}
+
+func (iso *IsoEx) CUPHeader() (CUPHeader, error) {
+	if iso.headertype&HEADER_CUP == 0 {
+		return CUPHeader{}, errors.New("no cup header")
+	}
+	return ParseCUPHeader(iso.headerBytes()[iso.cupOffset():])
+}
+
+func (iso *IsoEx) SetCUPHeader(h CUPHeader) error {
+	if iso.headertype&HEADER_CUP == 0 {
+		return errors.New("no cup header")
+	}
+	header := append([]byte(nil), iso.headerBytes()...)
+	copy(header[iso.cupOffset():], h.Bytes())
+	iso.header = header
+	return nil
+}
+
+/*
+NewResponse 生成应答报文:配置与报文头同请求,TPDU的源地址与目的地址互换,
+报文类型加10(0200->0210、0820->0830),不复制域.
+*/
+func (iso *IsoEx) NewResponse() (*IsoEx, error) {
+	mti := iso.MTI()
+	if len(mti) != 4 || (mti[2]-'0')%2 != 0 {
+		return nil, fmt.Errorf("mti [%s] is not a request", mti)
+	}
+	rsp := iso.Clone()
+	rsp.field = nil
+	rsp.buffer = nil
+	rsp.SetMTI(mti[:2] + string(mti[2]+1) + mti[3:])
+	if rsp.headertype&HEADER_TPDU != 0 {
+		t, _ := rsp.TPDU()
+		t.Dest, t.Src = t.Src, t.Dest
+		rsp.SetTPDU(t)
+	}
+	return rsp, nil
+}
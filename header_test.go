@@ -0,0 +1,105 @@
+package iso8583
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	iso, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso.SetHeaderType(HEADER_TPDU | HEADER_CUP)
+	data := []byte{0x60, 0x82, 0xdd, 0x03, 0x17, 0x60, 0x31, 0x10, 0x31, 0x30, 0x31,
+		0x08, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x01, 'T', '0', '1', ' ', ' ', ' ', ' ', ' '}
+	if err := iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	tpdu, err := iso.TPDU()
+	if err != nil || tpdu != (TPDU{0x60, 0x82dd, 0x0317}) {
+		t.Fatalf("TPDU %s %v", tpdu, err)
+	}
+	cup, err := iso.CUPHeader()
+	if err != nil || cup != (CUPHeader{0x60, 0x31, 1, 0, [3]byte{0x31, 0x30, 0x31}}) {
+		t.Fatalf("CUPHeader %s %v", cup, err)
+	}
+
+	rsp, err := iso.NewResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.SetField(39, []byte("00"))
+	cup.Process = 3
+	rsp.SetCUPHeader(cup)
+	data2, err := rsp.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{0x60, 0x03, 0x17, 0x82, 0xdd, 0x60, 0x31, 0x13, 0x31, 0x30, 0x31,
+		0x08, 0x10, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, '0', '0'}
+	if !bytes.Equal(data2, expect) {
+		t.Fatalf("response [% x]", data2)
+	}
+	if tpdu, _ = iso.TPDU(); tpdu.Src != 0x0317 {
+		t.Fatal("NewResponse changed request header")
+	}
+	if _, err = rsp.NewResponse(); err == nil {
+		t.Fatal("NewResponse accept response mti")
+	}
+
+	/*未设置报文头时为全0*/
+	iso2, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso2.SetHeaderType(HEADER_TPDU)
+	iso2.SetMTI("0800")
+	iso2.SetField(11, []byte("000001"))
+	if _, err = iso2.CUPHeader(); err == nil {
+		t.Fatal("CUPHeader without cup header type")
+	}
+	data3, _ := iso2.Iso2StrEx()
+	if !bytes.HasPrefix(data3, []byte{0, 0, 0, 0, 0, 0x08, 0x00}) {
+		t.Fatalf("Iso2StrEx [% x]", data3)
+	}
+	if err = iso2.SetHeaderType(0x04); err == nil {
+		t.Fatal("SetHeaderType accept unknown type")
+	}
+}
+
+/*带TPDU和银联报文头的完整报文*/
+func TestHeaderYL(t *testing.T) {
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	data := []byte{0x60, 0x82, 0xdd, 0x03, 0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x10, 0x70, 0x3e, 0x00, 0x81, 0x0a, 0xd0, 0x84, 0x12, 0x19, 0x88, 0x80, 0x19,
+		0x10, 0x04, 0x00, 0x36, 0x95, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x01, 0x63, 0x63, 0x09, 0x51, 0x49, 0x11, 0x29, 0x13,
+		0x11, 0x11, 0x29, 0x00, 0x08, 0x80, 0x19, 0x00, 0x00, 0x31, 0x31, 0x32, 0x39, 0x30, 0x30, 0x35, 0x33, 0x33, 0x38, 0x32, 0x38, 0x30, 0x30, 0x30, 0x34,
+		0x31, 0x38, 0x34, 0x32, 0x37, 0x39, 0x34, 0x31, 0x38, 0x31, 0x31, 0x30, 0x31, 0x35, 0x34, 0x31, 0x31, 0x33, 0x34, 0x35, 0x38, 0x19, 0x38, 0x30, 0x31,
+		0x39, 0x30, 0x30, 0x30, 0x30, 0x20, 0x20, 0x20, 0x38, 0x30, 0x31, 0x39, 0x30, 0x30, 0x30, 0x30, 0x31, 0x35, 0x36, 0x00, 0x20, 0x30, 0x32, 0x31, 0x30,
+		0x31, 0x35, 0x36, 0x43, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x00, 0x08, 0x22, 0x00, 0x64, 0x69, 0x00, 0x23, 0x43,
+		0x55, 0x50, 0xd6, 0xd0, 0xd0, 0xc0, 0x20, 0x20, 0xd3, 0xe0, 0xb6, 0xee, 0x30, 0x2e, 0x30, 0x30, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20}
+	iso.SetHeaderType(HEADER_TPDU | HEADER_CUP)
+	if err := iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	data2, err := iso.Iso2StrEx()
+	if err != nil || !bytes.Equal(data, data2) {
+		t.Fatalf("Iso2StrEx [% x] %v", data2, err)
+	}
+}
+
+/*带TPDU报文头的完整报文*/
+func TestHeaderJH(t *testing.T) {
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefJH)
+	data := []byte{0x60, 0x00, 0x00, 0x00, 0x13, 0x04, 0x00, 0x70, 0x38, 0x04, 0x81, 0xa8, 0xc0, 0x80, 0x15, 0x19, 0x88, 0x80, 0x19, 0x10, 0x00, 0x00, 0x79, 0x68, 0x68,
+		0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x55, 0x00, 0x52, 0x24, 0x11, 0x51, 0x35, 0x11, 0x29, 0x00, 0x20, 0x00, 0x08, 0x10, 0x00, 0x00,
+		0x01, 0x08, 0x30, 0x00, 0x00, 0x13, 0x33, 0x88, 0x80, 0x19, 0x10, 0x00, 0x00, 0x79, 0x68, 0x68, 0x4d, 0x00, 0x00, 0x00, 0x13, 0x70, 0x20, 0x20, 0x32,
+		0x30, 0x31, 0x33, 0x30, 0x37, 0x36, 0x37, 0x30, 0x36, 0x34, 0x33, 0x31, 0x30, 0x30, 0x30, 0x34, 0x31, 0x35, 0x38, 0x31, 0x30, 0x30, 0x30, 0x30, 0x30,
+		0x30, 0x35, 0x34, 0x31, 0x31, 0x30, 0x34, 0x33, 0x31, 0x31, 0x35, 0x36, 0x30, 0x31, 0x30, 0x30, 0x30, 0x30, 0x58, 0x34, 0x32, 0x42, 0x43, 0x4d, 0x50,
+		0x30, 0x30, 0x36, 0x00, 0x12, 0x30, 0x30, 0x30, 0x30, 0x34, 0x39, 0x30, 0x30, 0x35, 0x32, 0x32, 0x34, 0x45, 0x24, 0x02, 0xdd, 0xcd, 0xcf, 0x52, 0x2c}
+	iso.SetHeaderType(HEADER_TPDU)
+	if err := iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	data2, err := iso.Iso2StrEx()
+	if err != nil || !bytes.Equal(data, data2) {
+		t.Fatalf("Iso2StrEx [% x] %v", data2, err)
+	}
+	if tpdu, _ := iso.TPDU(); tpdu != (TPDU{0x60, 0x0000, 0x0013}) {
+		t.Fatalf("TPDU %s", tpdu)
+	}
+}
@@ -0,0 +1,436 @@
+package iso8583
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/*密钥类型,决定密钥的用途*/
+const KEY_TMK = "TMK" /*终端主密钥,加密工作密钥*/
+const KEY_ZMK = "ZMK" /*区域主密钥,加密工作密钥*/
+const KEY_PIK = "PIK" /*PIN密钥*/
+const KEY_ZPK = "ZPK" /*区域PIN密钥*/
+const KEY_MAK = "MAK" /*MAC密钥*/
+const KEY_TDK = "TDK" /*磁道加密密钥*/
+const KEY_AES = "AES" /*用于PIN块格式4的AES PIN密钥*/
+
+var ErrKeyNotFound = errors.New("key not found")
+var ErrBadKCV = errors.New("key check value mismatch")
+
+/*在KEK下加密的密钥,KCV为密钥校验值(可只取前几个字节)*/
+type WrappedKey struct {
+	Name string
+	Type string
+	Key  []byte
+	KCV  []byte
+}
+
+/*
+HSM 密钥的保管与使用.密钥按名称引用,明文密钥不离开HSM;
+MAC、PIN与密钥交换都可以通过HSM完成.SoftHSM为软件实现,可换为硬件加密机的适配.
+*/
+type HSM interface {
+	/*生成随机密钥,length为字节数,返回校验值*/
+	GenerateKey(name, typ string, length int) ([]byte, error)
+	/*导入在kek下加密的密钥,全部解密并校验通过后一起生效*/
+	ImportKeys(kek string, keys ...WrappedKey) error
+	/*导出在kek下加密的工作密钥(PIK、MAK、TDK、ZPK),主密钥不能导出*/
+	ExportKey(name, kek string) (WrappedKey, error)
+	KCV(name string) ([]byte, error)
+	GenerateMAC(name string, alg int, data []byte) ([]byte, error)
+	EncryptPIN(name string, format int, pin, pan string) ([]byte, error)
+	TranslatePIN(block []byte, pan string, from string, from_format int, to string, to_format int) ([]byte, error)
+}
+
+/*KEY_AES类型的密钥为AES,其他为DES/3DES*/
+func keyCipher(typ string, key []byte) (cipher.Block, error) {
+	if typ == KEY_AES {
+		return aes.NewCipher(key)
+	}
+	return desCipher(key)
+}
+
+/*KeyCheckValue 用密钥加密全0块,返回8字节(AES为16字节);通常只比较前3或4字节*/
+func KeyCheckValue(typ string, key []byte) ([]byte, error) {
+	c, err := keyCipher(typ, key)
+	if err != nil {
+		return nil, err
+	}
+	kcv := make([]byte, c.BlockSize())
+	c.Encrypt(kcv, kcv)
+	return kcv, nil
+}
+
+/*kcv为空时不校验,否则与完整校验值的前len(kcv)字节比较*/
+func checkKCV(full, kcv []byte) error {
+	if len(kcv) == 0 {
+		return nil
+	}
+	if len(kcv) > len(full) || subtle.ConstantTimeCompare(full[:len(kcv)], kcv) != 1 {
+		return ErrBadKCV
+	}
+	return nil
+}
+
+func ecbEncrypt(c cipher.Block, data []byte) ([]byte, error) {
+	n := c.BlockSize()
+	if len(data)%n != 0 {
+		return nil, fmt.Errorf("data length %d not a multiple of %d", len(data), n)
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += n {
+		c.Encrypt(out[i:i+n], data[i:i+n])
+	}
+	return out, nil
+}
+
+func ecbDecrypt(c cipher.Block, data []byte) ([]byte, error) {
+	n := c.BlockSize()
+	if len(data) == 0 || len(data)%n != 0 {
+		return nil, fmt.Errorf("data length %d not a multiple of %d", len(data), n)
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += n {
+		c.Decrypt(out[i:i+n], data[i:i+n])
+	}
+	return out, nil
+}
+
+type softKey struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Key  string `json:"key"` /*LMK加密,十六进制*/
+	KCV  string `json:"kcv"`
+}
+
+/*
+SoftHSM 软件HSM,用于开发与测试.密钥用LMK(AES-GCM)加密后保存在内存,
+设置了文件时每次修改后写入文件(JSON,权限0600).
+*/
+type SoftHSM struct {
+	lmk  cipher.AEAD
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]softKey
+}
+
+/*lmk为16、24或32字节的AES密钥;path为空时只保存在内存,文件存在时加载*/
+func NewSoftHSM(lmk []byte, path string) (*SoftHSM, error) {
+	block, err := aes.NewCipher(lmk)
+	if err != nil {
+		return nil, fmt.Errorf("lmk: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	h := &SoftHSM{lmk: aead, path: path, keys: make(map[string]softKey)}
+	if path == "" {
+		return h, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []softKey
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("key store %s: %w", path, err)
+	}
+	for _, k := range keys {
+		h.keys[k.Name] = k
+	}
+	/*检查LMK是否正确*/
+	for _, k := range keys {
+		if _, _, err = h.unwrap(k.Name); err != nil {
+			return nil, fmt.Errorf("key store %s: %w", path, err)
+		}
+	}
+	return h, nil
+}
+
+func (h *SoftHSM) wrap(name, typ string, key []byte) (softKey, error) {
+	kcv, err := KeyCheckValue(typ, key)
+	if err != nil {
+		return softKey{}, err
+	}
+	nonce := make([]byte, h.lmk.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return softKey{}, err
+	}
+	sealed := h.lmk.Seal(nonce, nonce, key, []byte(name+"|"+typ))
+	return softKey{Name: name, Type: typ, Key: hex.EncodeToString(sealed), KCV: fmt.Sprintf("%X", kcv)}, nil
+}
+
+/*取出明文密钥及类型,调用者持有读锁*/
+func (h *SoftHSM) unwrap(name string) ([]byte, string, error) {
+	k, ok := h.keys[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	sealed, err := hex.DecodeString(k.Key)
+	n := h.lmk.NonceSize()
+	if err != nil || len(sealed) < n {
+		return nil, "", fmt.Errorf("key %s: bad key data", name)
+	}
+	key, err := h.lmk.Open(nil, sealed[:n], sealed[n:], []byte(k.Name+"|"+k.Type))
+	if err != nil {
+		return nil, "", fmt.Errorf("key %s: lmk decrypt failed", name)
+	}
+	return key, k.Type, nil
+}
+
+func (h *SoftHSM) useKey(name string, types ...string) ([]byte, string, error) {
+	key, typ, err := h.unwrap(name)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, t := range types {
+		if t == typ {
+			return key, typ, nil
+		}
+	}
+	return nil, "", fmt.Errorf("key %s type %s not usable here", name, typ)
+}
+
+/*调用者持有写锁*/
+func (h *SoftHSM) save() error {
+	if h.path == "" {
+		return nil
+	}
+	keys := make([]softKey, 0, len(h.keys))
+	for _, k := range h.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), ".keys-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(append(data, '\n')); err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*SetKey 导入明文密钥(如手工输入的主密钥分量合成后),返回校验值*/
+func (h *SoftHSM) SetKey(name, typ string, key []byte) ([]byte, error) {
+	if _, err := keyCipher(typ, key); err != nil {
+		return nil, err
+	}
+	k, err := h.wrap(name, typ, key)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, had := h.keys[name]
+	h.keys[name] = k
+	if err = h.save(); err != nil {
+		if had {
+			h.keys[name] = old
+		} else {
+			delete(h.keys, name)
+		}
+		return nil, err
+	}
+	kcv, _ := hex.DecodeString(k.KCV)
+	return kcv, nil
+}
+
+func (h *SoftHSM) DeleteKey(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.keys[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	delete(h.keys, name)
+	return h.save()
+}
+
+/*密钥名称,按名称排序*/
+func (h *SoftHSM) Keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.keys))
+	for name := range h.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *SoftHSM) GenerateKey(name, typ string, length int) ([]byte, error) {
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return h.SetKey(name, typ, key)
+}
+
+func (h *SoftHSM) ImportKeys(kek string, keys ...WrappedKey) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kekey, kektype, err := h.useKey(kek, KEY_TMK, KEY_ZMK)
+	if err != nil {
+		return err
+	}
+	c, err := keyCipher(kektype, kekey)
+	if err != nil {
+		return err
+	}
+	wrapped := make([]softKey, 0, len(keys))
+	for _, wk := range keys {
+		key, err := ecbDecrypt(c, wk.Key)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", wk.Name, err)
+		}
+		full, err := KeyCheckValue(wk.Type, key)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", wk.Name, err)
+		}
+		if err = checkKCV(full, wk.KCV); err != nil {
+			return fmt.Errorf("key %s: %w", wk.Name, err)
+		}
+		k, err := h.wrap(wk.Name, wk.Type, key)
+		if err != nil {
+			return err
+		}
+		wrapped = append(wrapped, k)
+	}
+	old := make(map[string]softKey, len(h.keys))
+	for name, k := range h.keys {
+		old[name] = k
+	}
+	for _, k := range wrapped {
+		h.keys[k.Name] = k
+	}
+	if err = h.save(); err != nil {
+		h.keys = old
+		return err
+	}
+	return nil
+}
+
+/*可以导出的工作密钥类型*/
+var exportKeyTypes = []string{KEY_PIK, KEY_MAK, KEY_TDK, KEY_ZPK}
+
+func (h *SoftHSM) ExportKey(name, kek string) (WrappedKey, error) {
+	if name == kek {
+		return WrappedKey{}, fmt.Errorf("key %s cannot be exported under itself", name)
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	kekey, kektype, err := h.useKey(kek, KEY_TMK, KEY_ZMK)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	key, typ, err := h.useKey(name, exportKeyTypes...)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	c, err := keyCipher(kektype, kekey)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	enc, err := ecbEncrypt(c, key)
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("key %s: %w", name, err)
+	}
+	kcv, _ := hex.DecodeString(h.keys[name].KCV)
+	return WrappedKey{Name: name, Type: typ, Key: enc, KCV: kcv}, nil
+}
+
+func (h *SoftHSM) KCV(name string) ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	k, ok := h.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return hex.DecodeString(k.KCV)
+}
+
+func (h *SoftHSM) GenerateMAC(name string, alg int, data []byte) ([]byte, error) {
+	h.mu.RLock()
+	key, _, err := h.useKey(name, KEY_MAK)
+	h.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return ComputeMAC(alg, key, data)
+}
+
+/*格式4只用AES密钥,其他格式用DES类的PIN密钥*/
+func pinKeyTypes(format int) []string {
+	if format == PIN_FORMAT4 {
+		return []string{KEY_AES}
+	}
+	return []string{KEY_PIK, KEY_ZPK}
+}
+
+func (h *SoftHSM) EncryptPIN(name string, format int, pin, pan string) ([]byte, error) {
+	h.mu.RLock()
+	key, _, err := h.useKey(name, pinKeyTypes(format)...)
+	h.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return EncryptPINBlock(format, pin, pan, key)
+}
+
+func (h *SoftHSM) TranslatePIN(block []byte, pan string, from string, from_format int, to string, to_format int) ([]byte, error) {
+	h.mu.RLock()
+	from_key, _, err := h.useKey(from, pinKeyTypes(from_format)...)
+	var to_key []byte
+	if err == nil {
+		to_key, _, err = h.useKey(to, pinKeyTypes(to_format)...)
+	}
+	h.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return TranslatePINBlock(block, pan, from_format, from_key, to_format, to_key)
+}
+
+/*SetMACKey 通过HSM中名为name的MAK计算与校验MAC,见SetMAC*/
+func (iso *IsoEx) SetMACKey(h HSM, alg int, name string) {
+	iso.macfn = func(data []byte) ([]byte, error) {
+		return h.GenerateMAC(name, alg, data)
+	}
+}
+
+/*SetPINKey 通过HSM中名为name的PIN密钥加密PIN并设置第52域,主账号取第2域*/
+func (iso *IsoEx) SetPINKey(h HSM, name string, format int, pin string) error {
+	block, err := h.EncryptPIN(name, format, pin, string(iso.GetField(2)))
+	if err != nil {
+		return err
+	}
+	return iso.SetField(52, block)
+}
@@ -0,0 +1,153 @@
+package iso8583
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSoftHSMStore(t *testing.T) {
+	lmk := mustHex("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	path := filepath.Join(t.TempDir(), "keys.json")
+	h, err := NewSoftHSM(lmk, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmk := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	kcv, err := h.SetKey("T0000001/TMK", KEY_TMK, tmk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full, _ := KeyCheckValue(KEY_TMK, tmk); !bytes.Equal(kcv, full) {
+		t.Errorf("kcv %X, want %X", kcv, full)
+	}
+	if _, err = h.GenerateKey("T0000001/MAK", KEY_MAK, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.SetKey("bad", KEY_MAK, []byte("short")); err == nil {
+		t.Error("bad key length accepted")
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("key store mode %v", st.Mode())
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("0123456789ABCDEF")) || bytes.Contains(data, []byte("0123456789abcdef")) {
+		t.Error("clear key in key store")
+	}
+
+	h2, err := NewSoftHSM(lmk, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := h2.Keys(); len(names) != 2 || names[0] != "T0000001/MAK" {
+		t.Errorf("reloaded keys %v", names)
+	}
+	m1, _ := h.GenerateMAC("T0000001/MAK", MAC_X919, []byte("data"))
+	m2, err := h2.GenerateMAC("T0000001/MAK", MAC_X919, []byte("data"))
+	if err != nil || !bytes.Equal(m1, m2) {
+		t.Errorf("reloaded mac %X %X %v", m1, m2, err)
+	}
+	wrong := append([]byte(nil), lmk...)
+	wrong[0] ^= 1
+	if _, err = NewSoftHSM(wrong, path); err == nil {
+		t.Error("wrong lmk accepted")
+	}
+	if err = h.DeleteKey("T0000001/MAK"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.KCV("T0000001/MAK"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("deleted key: %v", err)
+	}
+}
+
+func TestSoftHSMExchange(t *testing.T) {
+	lmk := mustHex("00112233445566778899AABBCCDDEEFF")
+	host, _ := NewSoftHSM(lmk, "")
+	term, _ := NewSoftHSM(lmk, "")
+	tmk := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	host.SetKey("TMK", KEY_TMK, tmk)
+	term.SetKey("TMK", KEY_TMK, tmk)
+	host.GenerateKey("MAK", KEY_MAK, 16)
+	host.GenerateKey("PIK", KEY_PIK, 16)
+
+	mak, err := host.ExportKey("MAK", "TMK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pik, _ := host.ExportKey("PIK", "TMK")
+	if _, err = host.ExportKey("MAK", "PIK"); err == nil {
+		t.Error("export under a pin key")
+	}
+	host.SetKey("ZMK", KEY_ZMK, tmk)
+	if _, err = host.ExportKey("TMK", "ZMK"); err == nil {
+		t.Error("master key exported")
+	}
+	if _, err = host.ExportKey("TMK", "TMK"); err == nil {
+		t.Error("key exported under itself")
+	}
+
+	/*一个校验值错误时都不生效*/
+	bad := pik
+	bad.KCV = []byte{0, 0, 0, 0}
+	if err = term.ImportKeys("TMK", mak, bad); !errors.Is(err, ErrBadKCV) {
+		t.Fatalf("bad kcv: %v", err)
+	}
+	if _, err = term.KCV("MAK"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatal("partial import")
+	}
+	mak.KCV = mak.KCV[:4]
+	if err = term.ImportKeys("TMK", mak, pik); err != nil {
+		t.Fatal(err)
+	}
+
+	/*双方的MAC与PIN一致*/
+	iso := newTestMsg("0200", "000001")
+	iso.SetField(2, []byte("6222021234567890123"))
+	iso.SetMACKey(term, MAC_X919, "MAK")
+	if err = iso.SetPINKey(term, "PIK", PIN_FORMAT0, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rx := newTestMsg("0200", "000000")
+	rx.Reset()
+	rx.SetMACKey(host, MAC_X919, "MAK")
+	if err = rx.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = host.GenerateMAC("PIK", MAC_X919, data); err == nil {
+		t.Error("mac with a pin key")
+	}
+
+	host.GenerateKey("ZPK", KEY_ZPK, 24)
+	host.GenerateKey("AES", KEY_AES, 16)
+	block, err := host.TranslatePIN(rx.GetField(52), string(rx.GetField(2)), "PIK", PIN_FORMAT0, "ZPK", PIN_FORMAT3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, err = host.TranslatePIN(block, string(rx.GetField(2)), "ZPK", PIN_FORMAT3, "AES", PIN_FORMAT4); err != nil {
+		t.Fatal(err)
+	}
+	if len(block) != 16 {
+		t.Errorf("format 4 block %X", block)
+	}
+	/*格式与密钥类型须对应*/
+	if _, err = host.EncryptPIN("AES", PIN_FORMAT0, "123456", "6222021234567890123"); err == nil {
+		t.Error("format 0 with an aes key")
+	}
+	if _, err = host.EncryptPIN("PIK", PIN_FORMAT4, "123456", "6222021234567890123"); err == nil {
+		t.Error("format 4 with a des key")
+	}
+	if _, err = host.TranslatePIN(block, string(rx.GetField(2)), "AES", PIN_FORMAT4, "AES", PIN_FORMAT3); err == nil {
+		t.Error("format 3 with an aes key")
+	}
+}
@@ -1,11 +1,15 @@
 package iso8583
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
+	"strings"
 )
 
+/*Deprecated: 不再使用,用SetLogger开启跟踪日志*/
 const DEBUG = false
 const MAX_ISO_DATA = (1024 * 1)
 const MIN_ISO_LEN = 10
@@ -15,8 +19,10 @@ const ISO_LEN_MASK = 0x03 /*The Mask Is:When LenType=(Iso->type >> 6)*/
 const ISO_LEN_FIX = 0x00
 const ISO_LEN_VAR2 = 0x01
 const ISO_LEN_VAR3 = 0x02
+const ISO_LEN_VAR4 = 0x03
 
 /* 以下长度类型只能选一个 */
+const ISOLV4 = 0xC0
 const ISOLV3 = 0x80
 const ISOLV2 = 0x40
 const ISOLFIX = 0x00
@@ -43,6 +49,8 @@ const ISOLJUST = 0x00
 const BCDTYPE = 0
 const ASCTYPE = 1
 const HEXTYPE = 2
+const EBCDICTYPE = 3
+const BINTYPE = 4
 
 type IsoExDef struct {
 	length int16
@@ -55,12 +63,26 @@ type IsoField struct {
 }
 
 type IsoEx struct {
-	buffer  []byte
-	msgtype int16
-	bittype int16
-	lentype int16
-	field   []IsoField
-	iso_def []IsoExDef
+	buffer   []byte
+	msgtype  int16
+	bittype  int16
+	lentype  int16
+	field    []IsoField
+	fdef     []IsoFieldDef
+	trimpad  bool
+	hexlower bool
+	codepage int
+	jsonb64  bool
+	mask     MaskFunc
+	logmask  MaskFunc
+	logger   *slog.Logger
+	macfn    func(data []byte) ([]byte, error)
+	macfield int /*Str2IsoEx校验通过的MAC域号,0为没有*/
+	bitmaps  int /*Str2IsoEx解析到的位图个数,0为不是解析得到的报文*/
+
+	headerlen  int
+	headertype int
+	header     []byte
 }
 
 var IsoExDefYL = []IsoExDef{
@@ -252,169 +274,375 @@ func Bcd2Asc(bcd []byte, length int, r_align int) []byte {
 }
 
 func NewIsoEx(msgtype, bittype, lentype int16, isodef []IsoExDef) (*IsoEx, error) {
+	return NewIsoExFields(msgtype, bittype, lentype, ConvertIsoExDef(isodef))
+}
+
+/*使用扩展域定义,fielddef[i]为第i+1域的定义*/
+func NewIsoExFields(msgtype, bittype, lentype int16, fielddef []IsoFieldDef) (*IsoEx, error) {
+	for i := range fielddef {
+		if err := fielddef[i].check(); err != nil {
+			return nil, fmt.Errorf("field %d: %v", i+1, err)
+		}
+	}
 	iso := new(IsoEx)
 	iso.msgtype = msgtype
 	iso.bittype = bittype
 	iso.lentype = lentype
-	iso.iso_def = isodef
+	iso.fdef = make([]IsoFieldDef, len(fielddef))
+	copy(iso.fdef, fielddef)
 
 	return iso, nil
 }
 
+/*报文类型,4位数字*/
+func (iso *IsoEx) SetMTI(mti string) error {
+	if len(mti) != 4 {
+		return fmt.Errorf("mti [%s] must be 4 digits", mti)
+	}
+	for i := 0; i < 4; i++ {
+		if mti[i] < '0' || mti[i] > '9' {
+			return fmt.Errorf("mti [%s] must be 4 digits", mti)
+		}
+	}
+	iso.allocField(64)
+	iso.field[0].data = []byte(mti)
+	return nil
+}
+
+func (iso *IsoEx) MTI() string {
+	if len(iso.field) == 0 {
+		return ""
+	}
+	return string(iso.field[0].data)
+}
+
+/*n为ISO域号,从1开始;第1域为位图,不能直接设置.value会被复制,调用后可以复用*/
+func (iso *IsoEx) SetField(n int, value []byte) error {
+	if err := iso.checkFieldNo(n); err != nil {
+		return err
+	}
+	if err := iso.checkFieldValue(n-1, value); err != nil {
+		return err
+	}
+	iso.allocField((n + 63) / 64 * 64)
+	iso.field[n-1].data = append([]byte(nil), value...)
+	iso.field[n-1].length = int16(len(value))
+	iso.field[n-1].bitflag = 1
+	return nil
+}
+
+/*返回内部缓冲区,修改前需先复制*/
+func (iso *IsoEx) GetField(n int) []byte {
+	if !iso.HasField(n) {
+		return nil
+	}
+	return iso.field[n-1].data
+}
+
+func (iso *IsoEx) UnsetField(n int) {
+	if !iso.HasField(n) {
+		return
+	}
+	iso.field[n-1] = IsoField{}
+}
+
+func (iso *IsoEx) HasField(n int) bool {
+	if n < 2 || n > len(iso.field) {
+		return false
+	}
+	return iso.field[n-1].bitflag != 0
+}
+
+func (iso *IsoEx) allocField(num int) {
+	if len(iso.field) >= num {
+		return
+	}
+	field := make([]IsoField, num)
+	copy(field, iso.field)
+	iso.field = field
+}
+
+func (iso *IsoEx) checkFieldNo(n int) error {
+	if n < 2 || n > 192 {
+		return fmt.Errorf("field %d out of range 2..192", n)
+	}
+	if n > len(iso.fdef) || !iso.fdef[n-1].defined() {
+		return fmt.Errorf("field %d not defined", n)
+	}
+	if n == 65 && iso.maxBitmapLen() == 24 {
+		return errors.New("field 65 is the tertiary bitmap indicator")
+	}
+	return nil
+}
+
+/*定义超过128域时允许第三位图*/
+func (iso *IsoEx) maxBitmapLen() int {
+	if len(iso.fdef) > 128 {
+		return 24
+	}
+	return 16
+}
+
+/*
+位图个数:1为主位图,2含第二位图,3含第三位图.解析得到的报文至少为收到的位图个数(含空的扩展位图),
+打包时原样发送;否则由已设置的最大域号决定.
+*/
+func (iso *IsoEx) Bitmaps() int {
+	if len(iso.field) == 0 {
+		return 0
+	}
+	n := 1
+	for i := len(iso.field) - 1; i >= 64; i-- {
+		if iso.field[i].bitflag != 0 {
+			n = i/64 + 1
+			break
+		}
+	}
+	if iso.bitmaps > n {
+		n = iso.bitmaps
+	}
+	return n
+}
+
+/*域数据最大字节(字符)数*/
+func (iso *IsoEx) maxDataLen(bitno int) int {
+	return iso.fdef[bitno].valueLen(iso.fdef[bitno].Length)
+}
+
+func (iso *IsoEx) checkFieldValue(bitno int, value []byte) error {
+	def := &iso.fdef[bitno]
+	max_len := iso.maxDataLen(bitno)
+	if len(value) > max_len {
+		return fmt.Errorf("field %d length %d exceed max length %d", bitno+1, len(value), max_len)
+	}
+
+	switch def.DataEnc {
+	case ISODBCD:
+		for _, ch := range value {
+			if !isBcdChar(ch) {
+				return fmt.Errorf("field %d invalid bcd char [%c]", bitno+1, ch)
+			}
+		}
+	case ISODC_D:
+		if len(value) > 0 && value[0] != 'C' && value[0] != 'D' {
+			return fmt.Errorf("field %d must start with 'C' or 'D'", bitno+1)
+		}
+	}
+	return nil
+}
+
+/*BCD域允许0-9,A-F及磁道数据中的'='*/
+func isBcdChar(ch byte) bool {
+	return isHexChar(ch) || ch == '='
+}
+
+func isHexChar(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'A' && ch <= 'F') || (ch >= 'a' && ch <= 'f')
+}
+
+/*压缩BCD每个半字节都必须是0-9*/
+func isBcdDigits(bcd []byte) bool {
+	for _, b := range bcd {
+		if b>>4 > 9 || b&0x0f > 9 {
+			return false
+		}
+	}
+	return true
+}
+
 func (iso *IsoEx) Str2IsoEx(data []byte) error {
 	if len(data) == 0 {
 		return errors.New("data len err")
 	}
-	DumpHex(data)
+	if l := iso.tracing(); l != nil {
+		l.Debug("unpack", slog.Int("length", len(data)))
+	}
+	/*报文头之后的偏移从0开始计算*/
+	if iso.headerlen > 0 {
+		if len(data) < iso.headerlen {
+			return &ParseError{Field: -1, Expected: iso.headerlen, Available: len(data), Err: ErrShortBuffer}
+		}
+		iso.header = data[:iso.headerlen]
+		data = data[iso.headerlen:]
+	}
 	iso.buffer = data
-	buf_len := len(iso.buffer)
-	//DumpHex(iso.buffer[:2])
+	iso.macfield = 0
+	iso.bitmaps = 0
 	start := 0
 	var msgid []byte
 	if iso.msgtype == ASCTYPE {
+		if err := iso.need(0, start, 4); err != nil {
+			return err
+		}
 		msgid = iso.buffer[:4]
 		start += 4
+	} else if iso.msgtype == EBCDICTYPE {
+		if err := iso.need(0, start, 4); err != nil {
+			return err
+		}
+		msgid = Ebcdic2Asc(iso.buffer[:4], iso.codepage)
+		start += 4
 	} else {
+		if err := iso.need(0, start, 2); err != nil {
+			return err
+		}
+		if !isBcdDigits(iso.buffer[:2]) {
+			return &ParseError{Field: 0, Offset: start, Expected: 2, Available: 2, Err: ErrBadBCD}
+		}
 		msgid = Bcd2Asc(iso.buffer[:2], 4, 0)
 		start += 2
 	}
 
-	var bitnum int
+	/*位图首位为1表示后面还有扩展位图,定义超过128域时支持第三位图*/
 	var bitbuffer []byte
-	if iso.bittype == BCDTYPE {
-		if iso.buffer[start]&0x80 != 0x80 {
-			bitbuffer = iso.buffer[start : start+8]
-			bitnum = 8
+	for {
+		var bitmap []byte
+		if iso.bittype == BCDTYPE {
+			if err := iso.need(1, start, 8); err != nil {
+				return err
+			}
+			bitmap = iso.buffer[start : start+8]
 			start += 8
 		} else {
-			bitbuffer = iso.buffer[start : start+16]
-			bitnum = 16
+			if err := iso.need(1, start, 16); err != nil {
+				return err
+			}
+			/*十六进制字符,EBCDIC时先转为ASCII*/
+			hexmap := iso.buffer[start : start+16]
+			if iso.bittype == EBCDICTYPE {
+				hexmap = Ebcdic2Asc(hexmap, iso.codepage)
+			}
+			for _, ch := range hexmap {
+				if !isHexChar(ch) {
+					return &ParseError{Field: 1, Offset: start, Expected: 16, Available: len(iso.buffer) - start, Err: ErrBadBitmap}
+				}
+			}
+			bitmap = Asc2Bcd(hexmap, 16, 0)
 			start += 16
 		}
-	} else {
-		if iso.buffer[start]&0x80 != 0x80 {
-			bitbuffer = Asc2Bcd(iso.buffer[start:start+16], 16, 0)
-			bitnum = 8
-			start += 16
-		} else {
-			bitbuffer = Asc2Bcd(iso.buffer[start:start+32], 32, 0)
-			bitnum = 16
-			start += 32
+		bitbuffer = append(bitbuffer, bitmap...)
+		if bitmap[0]&0x80 == 0 || len(bitbuffer) >= iso.maxBitmapLen() {
+			break
 		}
 	}
-
-	if bitnum == 8 {
-		iso.field = make([]IsoField, 64)
-	} else {
-		iso.field = make([]IsoField, 128)
-	}
+	bitnum := len(bitbuffer)
+	iso.bitmaps = bitnum / 8
+	iso.field = make([]IsoField, bitnum*8)
 	iso.field[0].data = msgid
 	iso.field[1].data = bitbuffer
 
-	DumpHex(iso.field[0].data)
-	DumpHex(iso.field[1].data)
-	//fmt.Printf("start=[%d]\n", start)
+	if l := iso.tracing(); l != nil {
+		l.Debug("unpack header", slog.String("mti", string(msgid)), slog.String("bitmap", fmt.Sprintf("%X", bitbuffer)))
+	}
 	var i int
 	var j int
+	var err error
+	mac_bit := bitnum*8 - 1 /*MAC域为最后一个域:64、128或192*/
+	mac_start := -1
 
 	for i = 0; i < bitnum; i++ {
 		for j = 7; j >= 0; j-- {
 			j_bak := uint(j)
 			if (bitbuffer[i] & (0x01 << j_bak)) == 0 {
-				//fmt.Printf("[%02x]j=%d\n", bitbuffer[i], j)
-				//fmt.Printf("0")
 				continue
 			}
 			bit := (i+1)*8 - j - 1
-			if bit == 0 {
-				//fmt.Printf("1")
+			if bit == 0 || (bit == 64 && bitnum == 24) {
 				continue
 			}
-			//fmt.Printf("1")
-			//fmt.Printf("[%d][%d]1", i, j)
-			if start > buf_len {
-				return fmt.Errorf("iso.data Invalid Memory Access,now point to %d,and bit number is %d", start, bit)
+			if bit >= len(iso.fdef) || !iso.fdef[bit].defined() {
+				return &ParseError{Field: bit + 1, Offset: start, Err: ErrNoFieldDef}
+			}
+			if bit == mac_bit {
+				mac_start = start
+			} else if iso.macfn != nil && (bit+1)%64 == 0 {
+				/*MAC只能在最后一个位图的末位,其他位置的64/128域不能当作MAC*/
+				return &ParseError{Field: bit + 1, Offset: start, Err: ErrMACPosition}
+			}
+			start, err = iso.getFiledValue(bit, start)
+			if err != nil {
+				return err
 			}
-			start, _ = iso.getFiledValue(bit, start)
 		}
 	}
-	//fmt.Printf("\n")
+	if iso.macfn != nil && mac_start >= 0 {
+		if err = iso.verifyMAC(mac_bit+1, mac_start); err != nil {
+			return err
+		}
+		iso.macfield = mac_bit + 1
+	}
+	return nil
+}
+
+/*检查从start开始是否还有n个字节,field为ISO域号*/
+func (iso *IsoEx) need(field int, start int, n int) error {
+	if start+n > len(iso.buffer) {
+		return &ParseError{Field: field, Offset: start, Expected: n, Available: len(iso.buffer) - start, Err: ErrShortBuffer}
+	}
 	return nil
 }
 
 func (iso *IsoEx) getFiledValue(bitno int, start int) (int, error) {
-	len_type := int(iso.iso_def[bitno].def >> 6)
-	dat_type := int(iso.iso_def[bitno].def & 0x3F)
+	def := &iso.fdef[bitno]
+	offset := start
 
 	var length int
-	if len_type == 0 {
-		length = int(iso.iso_def[bitno].length)
+	if def.LenType == ISO_LEN_FIX {
+		length = def.Length
 	} else {
-		if iso.lentype == BCDTYPE {
-			if len_type == 1 {
-				length = int(iso.buffer[start]>>4)*10 + int(iso.buffer[start]&0x0f)
-				start += 1 //LENGTH LL2
-			} else {
-				length = int(iso.buffer[start]&0x0f)*100 + int((iso.buffer[start+1]>>4)*10) + int(iso.buffer[start+1]&0x0f)
-				start += 2 //LENGTH LL3
-			}
-		} else {
-			if len_type == 1 {
-				length, _ = strconv.Atoi(string(iso.buffer[start : start+2]))
-				start += 2 //LENGTH LL2
-			} else {
-				length, _ = strconv.Atoi(string(iso.buffer[start : start+3]))
-				start += 3 //LENGTH LL3
-			}
+		var err error
+		length, start, err = iso.readLength(bitno, start)
+		if err != nil {
+			return start, err
 		}
 	}
 
-	switch dat_type & ISO_DATA_MASK {
+	char_len := def.wireLen(length) /*借记,贷记数据,定义时没有包括'C'或 'D'*/
+	if err := iso.need(bitno+1, start, char_len); err != nil {
+		return start, err
+	}
+
+	switch def.DataEnc {
 	case ISODBCD:
-		var char_len int
-		if length%2 == 0 {
-			char_len = length / 2
-			iso.field[bitno].data = Bcd2Asc(iso.buffer[start:start+char_len], char_len*2, 0)
+		digits := def.valueLen(length)
+		if digits%2 == 1 && def.RightJust {
+			iso.field[bitno].data = Bcd2Asc(iso.buffer[start:start+char_len], digits, 1)
 		} else {
-			char_len = (length + 1) / 2
-			if iso.iso_def[bitno].def&ISO_JUST_MASK == 0x01 {
-				iso.field[bitno].data = Bcd2Asc(iso.buffer[start:start+char_len], length, 1)
-			} else {
-				iso.field[bitno].data = Bcd2Asc(iso.buffer[start:start+char_len], length, 0)
-			}
+			iso.field[bitno].data = Bcd2Asc(iso.buffer[start:start+char_len], digits, 0)
 		}
-		start += char_len
-	case ISODASC:
-		iso.field[bitno].data = iso.buffer[start : start+length]
-		start += length
-	case ISODBIN:
-		iso.field[bitno].data = iso.buffer[start : start+length/8]
-		start += length / 8
-	case ISODC_D:
-		iso.field[bitno].data = iso.buffer[start : start+length+1] /*借记,贷记数据,定义时没有包括'C'或 'D'*/
-		start += length + 1
+	case ISODEBC:
+		iso.field[bitno].data = Ebcdic2Asc(iso.buffer[start:start+char_len], iso.codepage)
 	default:
-		iso.field[bitno].data = iso.buffer[start : start+length]
-		start += length
+		iso.field[bitno].data = iso.buffer[start : start+char_len]
+	}
+	start += char_len
+	if iso.trimpad {
+		iso.field[bitno].data = iso.trimValue(bitno, iso.field[bitno].data)
 	}
 
 	iso.field[bitno].bitflag = 1
-	if len(iso.field[bitno].data) > int(iso.iso_def[bitno].length) {
-		return start, errors.New("filed data exceed def max length")
-	}
-	Debug("%03d--%03d--%03d--[%s]\n", bitno+1, length, start, iso.field[bitno].data)
+	iso.traceField("unpack field", bitno, offset, start-offset, iso.field[bitno].data)
 
 	return start, nil
 }
 
 func (iso *IsoEx) Iso2StrEx() ([]byte, error) {
-	bitnum := 8 //默认8字节位图
-	if len(iso.field) > 64 {
-		bitnum = 16
+	if iso.macfn != nil {
+		return iso.packMAC()
 	}
+	return iso.pack()
+}
+
+func (iso *IsoEx) pack() ([]byte, error) {
+	if len(iso.field) == 0 || len(iso.field[0].data) < 4 {
+		return nil, errors.New("mti not set")
+	}
+	bitnum := iso.Bitmaps() * 8
 	var msg_data []byte
 	if iso.msgtype == BCDTYPE {
 		msg_data = Asc2Bcd(iso.field[0].data, 4, 0)
+	} else if iso.msgtype == EBCDICTYPE {
+		msg_data = Asc2Ebcdic(iso.field[0].data[:4], iso.codepage)
 	} else {
 		msg_data = iso.field[0].data[:4]
 	}
@@ -424,11 +652,15 @@ func (iso *IsoEx) Iso2StrEx() ([]byte, error) {
 
 	bitbuffer = make([]byte, bitnum)
 	tmp_data = make([]byte, 0, 1024)
+	head_len := len(msg_data) + bitnum /*域在报文中的偏移,用于日志*/
+	if iso.bittype != BCDTYPE {
+		head_len += bitnum
+	}
 
 	for i = 0; i < bitnum; i++ {
 		for j = 7; j >= 0; j-- {
 			bit := (i+1)*8 - j - 1
-			if bit == 0 {
+			if bit == 0 || (bit == 64 && bitnum == 24) {
 				continue
 			}
 			if iso.field[bit].bitflag == 0 {
@@ -438,20 +670,40 @@ func (iso *IsoEx) Iso2StrEx() ([]byte, error) {
 			bitbuffer[i] |= (0x01 << j_bak)
 			ret_data, err := iso.setFiledValue(bit, iso.field[bit].data)
 			if err != nil {
-				return nil, errors.New("setFiledValue failed ")
+				return nil, err
 			}
+			iso.traceField("pack field", bit, head_len+len(tmp_data), len(ret_data), iso.field[bit].data)
 			tmp_data = append(tmp_data, ret_data...)
 		}
 	}
-	if bitnum == 16 {
+	if bitnum >= 16 {
 		bitbuffer[0] |= 0x80
 	}
-	// if iso.msgtype == ASCTYPE {
-	// 	tmp_bitbuffer = Bcd2Asc(bitbuffer, len(bitbuffer)*2, 0)
-	// }
+	if bitnum == 24 {
+		bitbuffer[8] |= 0x80
+	}
+	if iso.bittype != BCDTYPE {
+		bitbuffer = Bcd2Asc(bitbuffer, len(bitbuffer)*2, 0)
+		if iso.hexlower {
+			bitbuffer = bytes.ToLower(bitbuffer)
+		}
+		if iso.bittype == EBCDICTYPE {
+			bitbuffer = Asc2Ebcdic(bitbuffer, iso.codepage)
+		}
+	}
+	if iso.headerlen > 0 {
+		if iso.header != nil {
+			data = append(data, iso.header...)
+		} else {
+			data = append(data, make([]byte, iso.headerlen)...)
+		}
+	}
 	data = append(data, msg_data...)
 	data = append(data, bitbuffer...)
 	data = append(data, tmp_data...)
+	if l := iso.tracing(); l != nil {
+		l.Debug("pack", slog.String("mti", string(iso.field[0].data[:4])), slog.Int("length", len(data)))
+	}
 	return data, nil
 }
 
@@ -459,70 +711,297 @@ func (iso *IsoEx) setFiledValue(bitno int, data []byte) ([]byte, error) {
 	if iso.field[bitno].bitflag == 0 {
 		return nil, nil
 	}
-	len_type := int(iso.iso_def[bitno].def >> 6)
-	dat_type := int(iso.iso_def[bitno].def & 0x3F)
+	def := &iso.fdef[bitno]
+
+	max_len := iso.maxDataLen(bitno)
+	if len(data) > max_len {
+		return nil, fmt.Errorf("field %d length %d exceed max length %d", bitno+1, len(data), max_len)
+	}
+	if def.LenType == ISO_LEN_FIX {
+		data = iso.padValue(bitno, data)
+	}
 
 	var tmp_data []byte
 	char_len := len(data)
-	switch dat_type & ISO_DATA_MASK {
+	switch def.DataEnc {
 	case ISODBCD:
-		if iso.iso_def[bitno].def&ISO_JUST_MASK == 0x01 {
+		if def.RightJust {
 			tmp_data = Asc2Bcd(data, int32(char_len), 1)
 		} else {
 			tmp_data = Asc2Bcd(data, int32(char_len), 0)
 		}
+	case ISODEBC:
+		tmp_data = Asc2Ebcdic(data, iso.codepage)
 	default:
 		tmp_data = data
 	}
 
-	length := len(iso.field[bitno].data)
+	/*长度前缀按定义单位计算:二进制域为位数,借贷记域不含'C'/'D'*/
 	var filed_data []byte
-	switch len_type {
-	case ISO_LEN_FIX:
-		filed_data = tmp_data
-	case ISO_LEN_VAR2:
-		if iso.lentype == BCDTYPE {
-			filed_data = make([]byte, 1)
-			filed_data[0] = (byte(char_len/10) << 4) | byte(char_len%10)
-		} else {
-			filed_data = make([]byte, 2)
-			filed_data = []byte(fmt.Sprintf("%02d", length))
-		}
-		filed_data = append(filed_data, tmp_data...)
-	case ISO_LEN_VAR3:
-		if iso.lentype == BCDTYPE {
-			filed_data = make([]byte, 2)
-			filed_data[0] = byte(length / 100)
-			filed_data[1] = (byte(length/10) << 4) | byte(length%10)
-		} else {
-			filed_data = make([]byte, 3)
-			filed_data = []byte(fmt.Sprintf("%03d", length))
+	if def.LenType != ISO_LEN_FIX {
+		var err error
+		filed_data, err = iso.writeLength(bitno, def.units(char_len))
+		if err != nil {
+			return nil, err
 		}
-		filed_data = append(filed_data, tmp_data...)
 	}
-	DumpHex(filed_data)
+	filed_data = append(filed_data, tmp_data...)
 	return filed_data, nil
 
 }
-func DumpHex(data []byte) error {
-	if !DEBUG {
-		return nil
+
+/*域的长度前缀编码,未单独设置时使用报文的lentype*/
+func (iso *IsoEx) fieldLenType(bitno int) int16 {
+	return iso.fdef[bitno].lenEnc(iso.lentype)
+}
+
+/*
+长度前缀字节数:
+BCD     LL=1 LLL=2 LLLL=2
+BIN     LL=1 LLL=2 LLLL=2 (大端)
+ASC/EBCDIC/HEX  LL=2 LLL=3 LLLL=4
+*/
+func lenPrefixSize(lentype int16, len_type int) int {
+	switch lentype {
+	case BCDTYPE, BINTYPE:
+		if len_type == ISO_LEN_VAR2 {
+			return 1
+		}
+		return 2
 	}
-	for i := 0; i < len(data); i++ {
-		if (i+1)%25 != 0 {
-			fmt.Printf("%02x ", data[i])
-		} else {
-			fmt.Printf("%02x\n", data[i])
+	return len_type + 1
+}
+
+/*长度前缀能表示的最大长度*/
+func lenPrefixMax(lentype int16, len_type int) int {
+	switch lentype {
+	case BINTYPE:
+		if len_type == ISO_LEN_VAR2 {
+			return 0xFF
+		}
+		return 0xFFFF
+	case HEXTYPE:
+		return 1<<(4*uint(len_type+1)) - 1
+	}
+	max := 1
+	for i := 0; i <= len_type; i++ {
+		max *= 10
+	}
+	return max - 1
+}
+
+func (iso *IsoEx) readLength(bitno int, start int) (int, int, error) {
+	len_type := iso.fdef[bitno].LenType
+	lentype := iso.fieldLenType(bitno)
+	len_size := lenPrefixSize(lentype, len_type)
+	if err := iso.need(bitno+1, start, len_size); err != nil {
+		return 0, start, err
+	}
+	len_data := iso.buffer[start : start+len_size]
+
+	var length int
+	switch lentype {
+	case BCDTYPE:
+		if !isBcdDigits(len_data) {
+			return 0, start, &ParseError{Field: bitno + 1, Offset: start, Expected: len_size, Available: len_size, Err: ErrBadBCD}
+		}
+		for _, b := range len_data {
+			length = length*100 + int(b>>4)*10 + int(b&0x0f)
+		}
+	case BINTYPE:
+		for _, b := range len_data {
+			length = length<<8 | int(b)
+		}
+	default:
+		if lentype == EBCDICTYPE {
+			len_data = Ebcdic2Asc(len_data, iso.codepage)
+		}
+		base := 10
+		if lentype == HEXTYPE {
+			base = 16
+		}
+		for _, ch := range len_data {
+			if !isHexChar(ch) {
+				return 0, start, &ParseError{Field: bitno + 1, Offset: start, Expected: len_size, Available: len_size, Err: ErrBadLength}
+			}
+		}
+		value, err := strconv.ParseInt(string(len_data), base, 32)
+		if err != nil {
+			return 0, start, &ParseError{Field: bitno + 1, Offset: start, Expected: len_size, Available: len_size, Err: ErrBadLength}
+		}
+		length = int(value)
+	}
+
+	if length > iso.fdef[bitno].Length {
+		return 0, start, &ParseError{Field: bitno + 1, Offset: start, Expected: iso.fdef[bitno].Length, Available: length, Err: ErrFieldTooLong}
+	}
+	return length, start + len_size, nil
+}
+
+func (iso *IsoEx) writeLength(bitno int, length int) ([]byte, error) {
+	len_type := iso.fdef[bitno].LenType
+	lentype := iso.fieldLenType(bitno)
+	if length > lenPrefixMax(lentype, len_type) {
+		return nil, fmt.Errorf("field %d length %d overflow length prefix", bitno+1, length)
+	}
+	len_size := lenPrefixSize(lentype, len_type)
+
+	switch lentype {
+	case BCDTYPE:
+		return Asc2Bcd([]byte(fmt.Sprintf("%0*d", len_size*2, length)), int32(len_size*2), 0), nil
+	case BINTYPE:
+		len_data := make([]byte, len_size)
+		for i := len_size - 1; i >= 0; i-- {
+			len_data[i] = byte(length)
+			length >>= 8
+		}
+		return len_data, nil
+	case HEXTYPE:
+		digits := fmt.Sprintf("%0*X", len_size, length)
+		if iso.hexlower {
+			digits = strings.ToLower(digits)
 		}
+		return []byte(digits), nil
+	case EBCDICTYPE:
+		return Asc2Ebcdic([]byte(fmt.Sprintf("%0*d", len_size, length)), iso.codepage), nil
 	}
-	fmt.Printf("\n")
+	return []byte(fmt.Sprintf("%0*d", len_size, length)), nil
+}
+
+/*单独设置某个域的长度前缀编码,覆盖报文的lentype;n为ISO域号*/
+func (iso *IsoEx) SetFieldLenType(n int, lentype int16) error {
+	if err := iso.checkFieldNo(n); err != nil {
+		return err
+	}
+	if lentype < BCDTYPE || lentype > BINTYPE {
+		return fmt.Errorf("unsupported length type %d", lentype)
+	}
+	iso.fdef[n-1].LenEnc = lentype
+	iso.fdef[n-1].LenEncSet = true
 	return nil
 }
 
-func Debug(format string, a ...interface{}) error {
-	if !DEBUG {
-		return nil
+/*定长域按定义长度填充,右对齐填在左边,左对齐填在右边;借贷记域填在'C'/'D'之后*/
+func (iso *IsoEx) padValue(bitno int, data []byte) []byte {
+	max_len := iso.maxDataLen(bitno)
+	if len(data) >= max_len {
+		return data
+	}
+	def := &iso.fdef[bitno]
+	pad := bytes.Repeat([]byte{def.padByte()}, max_len-len(data))
+	value := make([]byte, 0, max_len)
+	if def.DataEnc == ISODC_D && len(data) > 0 {
+		value = append(value, data[0])
+		data = data[1:]
+	}
+	if def.RightJust {
+		value = append(value, pad...)
+		value = append(value, data...)
+	} else {
+		value = append(value, data...)
+		value = append(value, pad...)
+	}
+	return value
+}
+
+/*去掉定长域的填充字符,二进制域不处理;以'0'填充的数字全为0时保留一个0*/
+func (iso *IsoEx) trimValue(bitno int, data []byte) []byte {
+	def := &iso.fdef[bitno]
+	if def.LenType != ISO_LEN_FIX || def.DataEnc == ISODBIN {
+		return data
+	}
+	pad := string(def.padByte())
+	var sign []byte
+	if def.DataEnc == ISODC_D && len(data) > 0 {
+		sign = data[:1]
+		data = data[1:]
+	}
+	value := data
+	if def.RightJust {
+		data = bytes.TrimLeft(data, pad)
+	} else {
+		data = bytes.TrimRight(data, pad)
+	}
+	if len(data) == 0 && len(value) > 0 && pad == "0" {
+		data = value[len(value)-1:]
+	}
+	if sign != nil {
+		return append(append([]byte{}, sign...), data...)
+	}
+	return data
+}
+
+/*EBCDIC代码页,CP037(默认)或CP1047*/
+func (iso *IsoEx) SetCodePage(cp int) error {
+	if cp != CP037 && cp != CP1047 {
+		return fmt.Errorf("unsupported code page %d", cp)
 	}
-	fmt.Printf(format, a...)
+	iso.codepage = cp
 	return nil
 }
+
+/*ASCII位图及十六进制长度前缀打包时使用小写字符,默认大写*/
+func (iso *IsoEx) SetHexLower(lower bool) {
+	iso.hexlower = lower
+}
+
+/*解包时是否去掉定长域的填充字符,默认保留*/
+func (iso *IsoEx) SetTrimPad(trim bool) {
+	iso.trimpad = trim
+}
+
+/*报文头(如TPDU)长度,Str2IsoEx时截取保存,Iso2StrEx时加在报文类型之前,未设置时填0x00*/
+func (iso *IsoEx) SetHeaderLen(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid header length %d", n)
+	}
+	iso.headerlen = n
+	iso.headertype = 0
+	iso.header = nil
+	return nil
+}
+
+func (iso *IsoEx) HeaderLen() int {
+	return iso.headerlen
+}
+
+func (iso *IsoEx) Header() []byte {
+	return iso.header
+}
+
+func (iso *IsoEx) SetHeader(header []byte) error {
+	if len(header) != iso.headerlen {
+		return fmt.Errorf("header length %d, expect %d", len(header), iso.headerlen)
+	}
+	iso.header = header
+	return nil
+}
+
+/*复制报文,包括域定义、配置、报文头及域值*/
+func (iso *IsoEx) Clone() *IsoEx {
+	c := *iso
+	c.buffer = nil
+	c.fdef = append([]IsoFieldDef(nil), iso.fdef...)
+	if iso.header != nil {
+		c.header = append([]byte(nil), iso.header...)
+	}
+	if iso.field != nil {
+		c.field = make([]IsoField, len(iso.field))
+		for i, f := range iso.field {
+			c.field[i] = f
+			if f.data != nil {
+				c.field[i].data = append([]byte{}, f.data...)
+			}
+		}
+	}
+	return &c
+}
+
+/*清除报文类型、报文头及所有域,保留配置*/
+func (iso *IsoEx) Reset() {
+	iso.buffer = nil
+	iso.field = nil
+	iso.header = nil
+	iso.macfield = 0
+	iso.bitmaps = 0
+}
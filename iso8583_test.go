@@ -4,6 +4,7 @@ import (
 	"fmt"
 	//"strconv"
 	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -293,7 +294,9 @@ func TestStr2IsoEx(t *testing.T) {
 		0x33, 0x37, 0x32, 0x37, 0x34, 0x37, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x38, 0x38, 0x36, 0x35, 0x34, 0x33, 0x32, 0x31, 0x38, 0x31, 0x39, 0x35, 0x34,
 		0x31, 0x31, 0x30, 0x30, 0x30, 0x31, 0x30, 0x30, 0x30, 0x31, 0x31, 0x35, 0x36, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x25, 0x00,
 		0x00, 0x05, 0x00, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	iso.Str2IsoEx(data)
+	if err = iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
 
 	data2, err := iso.Iso2StrEx()
 	DumpHex(data2)
@@ -314,7 +317,9 @@ func TestStr2IsoEx2(t *testing.T) {
 		0x00, 0x00, 0x00, 0x75, 0x00, 0x00, 0x00, 0x26, 0x02, 0x20, 0x00, 0x16, 0x99, 0x95, 0x55, 0x21, 0x42, 0x36, 0x42, 0x97, 0x38, 0x38, 0x37, 0x37, 0x37,
 		0x37, 0x37, 0x31, 0x38, 0x31, 0x39, 0x35, 0x34, 0x31, 0x31, 0x30, 0x30, 0x30, 0x31, 0x30, 0x30, 0x30, 0x34, 0x31, 0x35, 0x36, 0x00, 0x08, 0x22, 0x00,
 		0x19, 0x12, 0x45, 0x42, 0x38, 0x45, 0x42, 0x46, 0x33, 0x30}
-	iso.Str2IsoEx(data)
+	if err = iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
 
 	data2, err := iso.Iso2StrEx()
 	DumpHex(data2)
@@ -338,7 +343,9 @@ func TestStr2IsoEx3(t *testing.T) {
 		0x39, 0x30, 0x30, 0x30, 0x30, 0x20, 0x20, 0x20, 0x38, 0x30, 0x31, 0x39, 0x30, 0x30, 0x30, 0x30, 0x31, 0x35, 0x36, 0x00, 0x20, 0x30, 0x32, 0x31, 0x30,
 		0x31, 0x35, 0x36, 0x43, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x00, 0x08, 0x22, 0x00, 0x64, 0x69, 0x00, 0x23, 0x43,
 		0x55, 0x50, 0xd6, 0xd0, 0xd0, 0xc0, 0x20, 0x20, 0xd3, 0xe0, 0xb6, 0xee, 0x30, 0x2e, 0x30, 0x30, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20}
-	iso.Str2IsoEx(data[11:])
+	if err = iso.Str2IsoEx(data[11:]); err != nil {
+		t.Fatal(err)
+	}
 
 	data2, err := iso.Iso2StrEx()
 	DumpHex(data2)
@@ -361,7 +368,9 @@ func TestStr2IsoExJH(t *testing.T) {
 		0x30, 0x31, 0x33, 0x30, 0x37, 0x36, 0x37, 0x30, 0x36, 0x34, 0x33, 0x31, 0x30, 0x30, 0x30, 0x34, 0x31, 0x35, 0x38, 0x31, 0x30, 0x30, 0x30, 0x30, 0x30,
 		0x30, 0x35, 0x34, 0x31, 0x31, 0x30, 0x34, 0x33, 0x31, 0x31, 0x35, 0x36, 0x30, 0x31, 0x30, 0x30, 0x30, 0x30, 0x58, 0x34, 0x32, 0x42, 0x43, 0x4d, 0x50,
 		0x30, 0x30, 0x36, 0x00, 0x12, 0x30, 0x30, 0x30, 0x30, 0x34, 0x39, 0x30, 0x30, 0x35, 0x32, 0x32, 0x34, 0x45, 0x24, 0x02, 0xdd, 0xcd, 0xcf, 0x52, 0x2c}
-	iso.Str2IsoEx(data[5:])
+	if err = iso.Str2IsoEx(data[5:]); err != nil {
+		t.Fatal(err)
+	}
 
 	data2, err := iso.Iso2StrEx()
 	DumpHex(data2)
@@ -373,11 +382,6 @@ func TestStr2IsoExJH(t *testing.T) {
 
 func TestStr2IsoExSC(t *testing.T) {
 	fmt.Println("start TestStr2IsoExSC")
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered in f", r)
-		}
-	}()
 	iso, err := NewIsoEx(1, 0, 1, IsoExDefScUnion)
 	if err != nil {
 		t.Fatal("new IsoEx err")
@@ -394,7 +398,9 @@ func TestStr2IsoExSC(t *testing.T) {
 		0xc8, 0xce, 0xb9, 0xab, 0xcb, 0xbe, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x31, 0x35, 0x36, 0x32, 0x36, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30,
 		0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x38, 0x39, 0x30, 0x39, 0x39, 0x30, 0x30, 0x30, 0x32, 0x30, 0x30, 0x36, 0x30, 0x30, 0x31, 0x30,
 		0x30, 0x35, 0x3a, 0x5f, 0xa1, 0xbd, 0xbf, 0xf4, 0xf2, 0xdf}
-	iso.Str2IsoEx(data)
+	if err = iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
 
 	data2, err := iso.Iso2StrEx()
 	DumpHex(data2)
@@ -404,6 +410,130 @@ func TestStr2IsoExSC(t *testing.T) {
 	//fmt.Println(iso)
 }
 
+func TestPadField(t *testing.T) {
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(4, []byte("100"))        /*BCD 右对齐补0*/
+	iso.SetField(11, []byte("12"))        /*BCD 右对齐补0*/
+	iso.SetField(37, []byte("REF1"))      /*ASC 左对齐补空格*/
+	iso.SetField(41, []byte("42"))        /*ASC 左对齐补空格*/
+	iso.SetField(49, []byte("6"))         /*ASC 右对齐补0*/
+	iso.SetField(28, []byte("C500"))      /*借贷记 补在'C'之后*/
+	iso.SetField(36, []byte("123456789")) /*变长域不填充*/
+	iso.SetField(64, []byte{0x01, 0x02})  /*二进制 补0x00*/
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iso2, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[int]string{
+		4:  "000000000100",
+		11: "000012",
+		28: "C00000500",
+		36: "123456789",
+		37: "REF1        ",
+		41: "42      ",
+		49: "006",
+		64: "\x01\x02\x00\x00\x00\x00\x00\x00",
+	}
+	for n, v := range expect {
+		if string(iso2.GetField(n)) != v {
+			t.Fatalf("field %d [%s] != [%s]", n, iso2.GetField(n), v)
+		}
+	}
+
+	iso2.SetTrimPad(true)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	trimmed := map[int]string{4: "100", 11: "12", 28: "C500", 36: "123456789", 37: "REF1", 41: "42", 49: "6"}
+	for n, v := range trimmed {
+		if string(iso2.GetField(n)) != v {
+			t.Fatalf("field %d [%s] != [%s]", n, iso2.GetField(n), v)
+		}
+	}
+	data2, err := iso2.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, data2) != 0 {
+		t.Fatal("Compare data and data2 failed")
+	}
+
+	/*金额为0时保留一个0,不变成空值*/
+	iso.SetField(4, []byte("000000000000"))
+	iso.SetField(28, []byte("C0"))
+	data, _ = iso.Iso2StrEx()
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if string(iso2.GetField(4)) != "0" || string(iso2.GetField(28)) != "C0" {
+		t.Fatalf("zero amount [%s] [%s]", iso2.GetField(4), iso2.GetField(28))
+	}
+
+	iso.field[3].data = []byte("1234567890123")
+	if _, err = iso.Iso2StrEx(); err == nil {
+		t.Fatal("Iso2StrEx accept over-length field")
+	}
+}
+
+func TestStr2IsoExTruncated(t *testing.T) {
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(4, []byte("000000000100"))
+	iso.SetField(41, []byte("12345678"))
+	iso.SetField(62, []byte("key data"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(data); i++ {
+		err = iso.Str2IsoEx(data[:i])
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("truncated at %d: want *ParseError, got %v", i, err)
+		}
+		if perr.Err != ErrShortBuffer {
+			t.Fatalf("truncated at %d: %v", i, err)
+		}
+	}
+
+	bad := append([]byte{}, data...)
+	bad[10] = 0x1a /*第2域LL长度*/
+	err = iso.Str2IsoEx(bad)
+	if perr, ok := err.(*ParseError); !ok || perr.Field != 2 || !errors.Is(err, ErrBadBCD) {
+		t.Fatalf("bad bcd length: %v", err)
+	}
+	bad[10] = 0x21
+	err = iso.Str2IsoEx(bad)
+	if perr, ok := err.(*ParseError); !ok || perr.Field != 2 || !errors.Is(err, ErrFieldTooLong) {
+		t.Fatalf("field too long: %v", err)
+	}
+
+	asc, _ := NewIsoEx(1, 1, 1, IsoExDefScUnion)
+	err = asc.Str2IsoEx([]byte("02000000000000000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = asc.Str2IsoEx([]byte("020040000000000000000512"))
+	if !errors.Is(err, ErrShortBuffer) {
+		t.Fatal(err)
+	}
+	err = asc.Str2IsoEx([]byte("02004000000000000000x512345"))
+	if !errors.Is(err, ErrBadLength) {
+		t.Fatal(err)
+	}
+	err = asc.Str2IsoEx([]byte("0200G000000000000000"))
+	if !errors.Is(err, ErrBadBitmap) {
+		t.Fatal(err)
+	}
+}
+
 func BenchmarkIsoExSC(b *testing.B) {
 	fmt.Println("start BenchmarkIsoExSC Str2IsoExSC and Iso2StrExSC")
 	iso, err := NewIsoEx(1, 0, 1, IsoExDefScUnion)
@@ -433,3 +563,338 @@ func BenchmarkIsoExSC(b *testing.B) {
 		fmt.Println("Compare data and data2 failed")
 	}
 }
+
+func TestSetField(t *testing.T) {
+	iso, err := NewIsoEx(0, 0, 0, IsoExDefYL)
+	if err != nil {
+		t.Fatal("new IsoEx err")
+	}
+	if _, err = iso.Iso2StrEx(); err == nil {
+		t.Fatal("Iso2StrEx without mti should fail")
+	}
+	if err = iso.SetMTI("02x0"); err == nil {
+		t.Fatal("SetMTI accept invalid mti")
+	}
+	if err = iso.SetMTI("0200"); err != nil {
+		t.Fatal(err)
+	}
+	if err = iso.SetField(1, []byte("00")); err == nil {
+		t.Fatal("SetField accept field 1")
+	}
+	if err = iso.SetField(3, []byte("0000000")); err == nil {
+		t.Fatal("SetField accept over-length fixed field")
+	}
+	if err = iso.SetField(2, []byte("62220200000000001234")); err == nil {
+		t.Fatal("SetField accept over-length field")
+	}
+	if err = iso.SetField(2, []byte("622202000000X234")); err == nil {
+		t.Fatal("SetField accept invalid bcd")
+	}
+
+	values := map[int]string{
+		2:  "6222020000001234",
+		3:  "000000",
+		4:  "000000000100",
+		11: "000001",
+		22: "021",
+		25: "00",
+		41: "12345678",
+		42: "123456789012345",
+		49: "156",
+		62: "key data",
+		64: "\x01\x02\x03\x04\x05\x06\x07\x08",
+	}
+	for n, v := range values {
+		if err = iso.SetField(n, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !iso.HasField(41) || iso.HasField(39) {
+		t.Fatal("HasField failed")
+	}
+	/*SetField复制value,调用方修改原切片不影响域值*/
+	buf := []byte("000002")
+	iso.SetField(11, buf)
+	buf[5] = '9'
+	if string(iso.GetField(11)) != "000002" {
+		t.Fatalf("SetField alias caller buffer [%s]", iso.GetField(11))
+	}
+	iso.SetField(11, []byte(values[11]))
+	iso.UnsetField(62)
+	if iso.HasField(62) || iso.GetField(62) != nil {
+		t.Fatal("UnsetField failed")
+	}
+	delete(values, 62)
+
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso2, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if iso2.MTI() != "0200" {
+		t.Fatalf("MTI [%s]", iso2.MTI())
+	}
+	for n, v := range values {
+		if string(iso2.GetField(n)) != v {
+			t.Fatalf("field %d [%s] != [%s]", n, iso2.GetField(n), v)
+		}
+	}
+
+	if err = iso.SetField(100, []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	data, err = iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if string(iso2.GetField(100)) != "12345" || string(iso2.GetField(2)) != values[2] {
+		t.Fatal("secondary bitmap field failed")
+	}
+}
+
+func TestAscBitmap(t *testing.T) {
+	tests := []struct {
+		lower  bool
+		fields []int
+		bitmap string
+	}{
+		{false, []int{2, 11, 41}, "4020000000800000"},
+		{true, []int{3, 4, 11, 39, 41, 62}, "3020000002800004"},
+		{false, []int{3, 11, 70}, "A0200000000000000400000000000000"},
+		{true, []int{2, 100, 128}, "c0000000000000000000000010000001"},
+	}
+	values := map[int]string{2: "6222020000001234", 3: "000000", 4: "000000000100", 11: "000001",
+		39: "00", 41: "12345678", 62: "key", 70: "001", 100: "12345", 128: "12345678"}
+
+	for _, tt := range tests {
+		iso, _ := NewIsoEx(1, 1, 1, IsoExDefScUnion)
+		iso.SetHexLower(tt.lower)
+		iso.SetMTI("0800")
+		for _, n := range tt.fields {
+			if err := iso.SetField(n, []byte(values[n])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := iso.Iso2StrEx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data[4:4+len(tt.bitmap)]) != tt.bitmap {
+			t.Fatalf("bitmap [%s] != [%s]", data[4:4+len(tt.bitmap)], tt.bitmap)
+		}
+
+		iso2, _ := NewIsoEx(1, 1, 1, IsoExDefScUnion)
+		if err = iso2.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range tt.fields {
+			if string(iso2.GetField(n)) != values[n] {
+				t.Fatalf("field %d [%s] != [%s]", n, iso2.GetField(n), values[n])
+			}
+		}
+		iso2.SetHexLower(tt.lower)
+		data2, err := iso2.Iso2StrEx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(data, data2) != 0 {
+			t.Fatal("Compare data and data2 failed")
+		}
+	}
+}
+
+func TestEbcdicBitmap(t *testing.T) {
+	iso, _ := NewIsoEx(EBCDICTYPE, EBCDICTYPE, EBCDICTYPE, IsoExDefScUnion)
+	iso.SetMTI("0800")
+	iso.SetField(3, []byte("000000"))
+	iso.SetField(11, []byte("000001"))
+	iso.SetField(70, []byte("001"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := Asc2Ebcdic([]byte("A0200000000000000400000000000000"), CP037)
+	if !bytes.Equal(data[4:4+len(bitmap)], bitmap) {
+		t.Fatalf("bitmap % X", data[4:4+len(bitmap)])
+	}
+	iso2, _ := NewIsoEx(EBCDICTYPE, EBCDICTYPE, EBCDICTYPE, IsoExDefScUnion)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if string(iso2.GetField(11)) != "000001" || string(iso2.GetField(70)) != "001" {
+		t.Fatalf("field 11 [%s] field 70 [%s]", iso2.GetField(11), iso2.GetField(70))
+	}
+	/*ASCII十六进制位图不能按EBCDIC解析*/
+	iso.bittype = ASCTYPE
+	data, _ = iso.Iso2StrEx()
+	if err = iso2.Str2IsoEx(data); !errors.Is(err, ErrBadBitmap) {
+		t.Fatalf("ascii bitmap: %v", err)
+	}
+}
+
+func TestTertiaryBitmap(t *testing.T) {
+	def := append([]IsoExDef{}, IsoExDefYL...)
+	for i := 129; i <= 192; i++ {
+		def = append(def, IsoExDef{99, ISOLV2 | ISODASC | ISOFSP | ISOLJUST})
+	}
+
+	for _, bittype := range []int16{BCDTYPE, ASCTYPE} {
+		iso, _ := NewIsoEx(0, bittype, 0, def)
+		iso.SetMTI("0200")
+		if err := iso.SetField(65, []byte("12345678")); err == nil {
+			t.Fatal("SetField accept field 65 with tertiary bitmap")
+		}
+		values := map[int]string{2: "6222020000001234", 70: "001", 130: "tertiary", 192: "last"}
+		for n, v := range values {
+			if err := iso.SetField(n, []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := iso.Iso2StrEx()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iso2, _ := NewIsoEx(0, bittype, 0, def)
+		if err = iso2.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		if iso2.Bitmaps() != 3 {
+			t.Fatalf("Bitmaps %d", iso2.Bitmaps())
+		}
+		for n, v := range values {
+			if string(iso2.GetField(n)) != v {
+				t.Fatalf("field %d [%s] != [%s]", n, iso2.GetField(n), v)
+			}
+		}
+		data2, _ := iso2.Iso2StrEx()
+		if bytes.Compare(data, data2) != 0 {
+			t.Fatal("Compare data and data2 failed")
+		}
+
+		iso2.UnsetField(130)
+		iso2.UnsetField(192)
+		iso3, _ := NewIsoEx(0, bittype, 0, def)
+		data, _ = iso2.Iso2StrEx()
+		if err = iso3.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		if iso3.Bitmaps() != 3 || string(iso3.GetField(70)) != "001" {
+			t.Fatalf("Bitmaps %d", iso3.Bitmaps())
+		}
+		/*收到的空第三位图原样发送*/
+		if data2, _ = iso3.Iso2StrEx(); !bytes.Equal(data, data2) {
+			t.Fatal("empty tertiary bitmap not kept")
+		}
+	}
+
+	/*128域定义时第65域仍按数据域处理*/
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(65, []byte("\x01\x02\x03\x04\x05\x06\x07\x08"))
+	data, _ := iso.Iso2StrEx()
+	iso2, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	if err := iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if iso2.Bitmaps() != 2 || string(iso2.GetField(65)) != "\x01\x02\x03\x04\x05\x06\x07\x08" {
+		t.Fatal("field 65 failed")
+	}
+}
+
+func TestLenType(t *testing.T) {
+	def := append([]IsoExDef{}, IsoExDefScUnion...)
+	def[43] = IsoExDef{300, ISOLV2 | ISODASC | ISOFSP | ISOLJUST}
+	def[47] = IsoExDef{999, ISOLV3 | ISODASC | ISOFSP | ISOLJUST}
+	def[62] = IsoExDef{9999, ISOLV4 | ISODASC | ISOFSP | ISOLJUST}
+
+	value := bytes.Repeat([]byte("x"), 26)
+	tests := []struct {
+		lentype    int16
+		ll, lll    string
+		llll       string
+		overflowLL bool
+	}{
+		{BCDTYPE, "\x26", "\x00\x26", "\x00\x26", true},
+		{ASCTYPE, "26", "026", "0026", true},
+		{EBCDICTYPE, "\xf2\xf6", "\xf0\xf2\xf6", "\xf0\xf0\xf2\xf6", true},
+		{HEXTYPE, "1a", "01a", "001a", false},
+		{BINTYPE, "\x1a", "\x00\x1a", "\x00\x1a", false},
+	}
+	for _, tt := range tests {
+		iso, _ := NewIsoEx(ASCTYPE, ASCTYPE, tt.lentype, def)
+		iso.SetHexLower(true)
+		iso.SetMTI("0200")
+		iso.SetField(44, value)
+		iso.SetField(48, value)
+		iso.SetField(63, value)
+		data, err := iso.Iso2StrEx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []byte("0200")
+		expect = append(expect, []byte("0000000000110002")...)
+		expect = append(expect, tt.ll...)
+		expect = append(expect, value...)
+		expect = append(expect, tt.lll...)
+		expect = append(expect, value...)
+		expect = append(expect, tt.llll...)
+		expect = append(expect, value...)
+		if bytes.Compare(data, expect) != 0 {
+			t.Fatalf("lentype %d [% x]", tt.lentype, data)
+		}
+
+		iso2, _ := NewIsoEx(ASCTYPE, ASCTYPE, tt.lentype, def)
+		if err = iso2.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(iso2.GetField(63), value) != 0 {
+			t.Fatalf("lentype %d Str2IsoEx failed", tt.lentype)
+		}
+
+		iso.SetField(44, bytes.Repeat([]byte("y"), 120))
+		if _, err = iso.Iso2StrEx(); (err != nil) != tt.overflowLL {
+			t.Fatalf("lentype %d overflow: %v", tt.lentype, err)
+		}
+	}
+
+	/*第48域单独使用二进制长度,其余为ASCII*/
+	iso, _ := NewIsoEx(ASCTYPE, ASCTYPE, ASCTYPE, def)
+	if err := iso.SetFieldLenType(48, 9); err == nil {
+		t.Fatal("SetFieldLenType accept 9")
+	}
+	iso.SetFieldLenType(48, BINTYPE)
+	iso.SetMTI("0200")
+	iso.SetField(44, value)
+	iso.SetField(48, value)
+	data, _ := iso.Iso2StrEx()
+	if bytes.Index(data, append([]byte("26"), value...)) != 20 || bytes.Index(data, append([]byte{0x00, 0x1a}, value...)) != 48 {
+		t.Fatalf("SetFieldLenType [% x]", data)
+	}
+	iso2, _ := NewIsoEx(ASCTYPE, ASCTYPE, ASCTYPE, def)
+	iso2.SetFieldLenType(48, BINTYPE)
+	if err := iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(iso2.GetField(48), value) != 0 {
+		t.Fatal("SetFieldLenType Str2IsoEx failed")
+	}
+}
+
+/*新建的报文去掉扩展域后不发送空的第二位图*/
+func TestBitmapsUnset(t *testing.T) {
+	iso, _ := NewIsoEx(0, 0, 0, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(70, []byte("001"))
+	iso.UnsetField(70)
+	data, _ := iso.Iso2StrEx()
+	if iso.Bitmaps() != 1 || len(data) != 10 {
+		t.Fatalf("Bitmaps after unset field 70: %d [% x]", iso.Bitmaps(), data)
+	}
+}
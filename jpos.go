@@ -0,0 +1,239 @@
+package iso8583
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*jPOS GenericPackager的XML格式*/
+type jposPackager struct {
+	XMLName xml.Name    `xml:"isopackager"`
+	Fields  []jposField `xml:"isofield"`
+	Subs    []jposField `xml:"isofieldpackager"`
+}
+
+type jposField struct {
+	ID     string `xml:"id,attr"`
+	Length string `xml:"length,attr"`
+	Name   string `xml:"name,attr"`
+	Class  string `xml:"class,attr"`
+	Pad    string `xml:"pad,attr"`
+}
+
+/*jPOS域打包类对应的长度类型、长度前缀编码及数据编码*/
+type jposClass struct {
+	lenType int
+	lenEnc  int16
+	dataEnc int
+	numeric bool /*数字域:定长时右对齐补'0'*/
+	inBytes bool
+}
+
+var jposClasses = map[string]jposClass{
+	"IF_CHAR":  {ISO_LEN_FIX, LENDEFAULT, ISODASC, false, false},
+	"IF_ECHAR": {ISO_LEN_FIX, LENDEFAULT, ISODEBC, false, false},
+
+	"IFA_NUMERIC":  {ISO_LEN_FIX, LENDEFAULT, ISODASC, true, false},
+	"IFA_AMOUNT":   {ISO_LEN_FIX, LENDEFAULT, ISODC_D, true, false},
+	"IFA_LLNUM":    {ISO_LEN_VAR2, ASCTYPE, ISODASC, true, false},
+	"IFA_LLLNUM":   {ISO_LEN_VAR3, ASCTYPE, ISODASC, true, false},
+	"IFA_LLCHAR":   {ISO_LEN_VAR2, ASCTYPE, ISODASC, false, false},
+	"IFA_LLLCHAR":  {ISO_LEN_VAR3, ASCTYPE, ISODASC, false, false},
+	"IFA_LLLLCHAR": {ISO_LEN_VAR4, ASCTYPE, ISODASC, false, false},
+	"IFA_LLBNUM":   {ISO_LEN_VAR2, ASCTYPE, ISODBCD, true, false},
+	"IFA_LLLBNUM":  {ISO_LEN_VAR3, ASCTYPE, ISODBCD, true, false},
+
+	"IFB_NUMERIC":    {ISO_LEN_FIX, LENDEFAULT, ISODBCD, true, false},
+	"IFB_LLNUM":      {ISO_LEN_VAR2, BCDTYPE, ISODBCD, true, false},
+	"IFB_LLLNUM":     {ISO_LEN_VAR3, BCDTYPE, ISODBCD, true, false},
+	"IFB_LLCHAR":     {ISO_LEN_VAR2, BCDTYPE, ISODASC, false, false},
+	"IFB_LLLCHAR":    {ISO_LEN_VAR3, BCDTYPE, ISODASC, false, false},
+	"IFB_LLLLCHAR":   {ISO_LEN_VAR4, BCDTYPE, ISODASC, false, false},
+	"IFB_BINARY":     {ISO_LEN_FIX, LENDEFAULT, ISODBIN, false, true},
+	"IFB_LLBINARY":   {ISO_LEN_VAR2, BCDTYPE, ISODBIN, false, true},
+	"IFB_LLLBINARY":  {ISO_LEN_VAR3, BCDTYPE, ISODBIN, false, true},
+	"IFB_LLLLBINARY": {ISO_LEN_VAR4, BCDTYPE, ISODBIN, false, true},
+	"IFB_LLHNUM":     {ISO_LEN_VAR2, BINTYPE, ISODBCD, true, false},
+	"IFB_LLHCHAR":    {ISO_LEN_VAR2, BINTYPE, ISODASC, false, false},
+	"IFB_LLLHCHAR":   {ISO_LEN_VAR3, BINTYPE, ISODASC, false, false},
+	"IFB_LLHBINARY":  {ISO_LEN_VAR2, BINTYPE, ISODBIN, false, true},
+	"IFB_LLLHBINARY": {ISO_LEN_VAR3, BINTYPE, ISODBIN, false, true},
+
+	"IFE_NUMERIC": {ISO_LEN_FIX, LENDEFAULT, ISODEBC, true, false},
+	"IFE_CHAR":    {ISO_LEN_FIX, LENDEFAULT, ISODEBC, false, false},
+	"IFE_LLNUM":   {ISO_LEN_VAR2, EBCDICTYPE, ISODEBC, true, false},
+	"IFE_LLLNUM":  {ISO_LEN_VAR3, EBCDICTYPE, ISODEBC, true, false},
+	"IFE_LLCHAR":  {ISO_LEN_VAR2, EBCDICTYPE, ISODEBC, false, false},
+	"IFE_LLLCHAR": {ISO_LEN_VAR3, EBCDICTYPE, ISODEBC, false, false},
+	"IFE_BINARY":  {ISO_LEN_FIX, LENDEFAULT, ISODBIN, false, true},
+
+	"IFEB_LLNUM":    {ISO_LEN_VAR2, EBCDICTYPE, ISODBCD, true, false},
+	"IFEB_LLLNUM":   {ISO_LEN_VAR3, EBCDICTYPE, ISODBCD, true, false},
+	"IFEP_LLCHAR":   {ISO_LEN_VAR2, EBCDICTYPE, ISODASC, false, false},
+	"IFEB_LLBINARY": {ISO_LEN_VAR2, EBCDICTYPE, ISODBIN, false, true},
+}
+
+/*报文类型(第0域)与位图(第1域)的类*/
+var jposMTIClasses = map[string]int16{
+	"IFA_NUMERIC": ASCTYPE,
+	"IF_CHAR":     ASCTYPE,
+	"IFB_NUMERIC": BCDTYPE,
+	"IFE_NUMERIC": EBCDICTYPE,
+	"IF_ECHAR":    EBCDICTYPE,
+}
+
+var jposBitmapClasses = map[string]int16{
+	"IFA_BITMAP": ASCTYPE,
+	"IFB_BITMAP": BCDTYPE,
+	"IFE_BITMAP": EBCDICTYPE,
+}
+
+/*
+ImportJPOS 把jPOS GenericPackager的XML定义转换为报文规范.
+无法表示的域(如IFA_BINARY、IFB_AMOUNT、子域打包器)不定义,在返回的列表中说明.
+变长域的长度前缀编码取最常用的一种作为规范的LenType,其余域单独指定.
+*/
+func ImportJPOS(r io.Reader) (*Spec, []string, error) {
+	var pkg jposPackager
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	if err := dec.Decode(&pkg); err != nil {
+		return nil, nil, fmt.Errorf("jpos: %v", err)
+	}
+
+	spec := &Spec{MsgType: ASCTYPE, BitType: BCDTYPE, LenType: BCDTYPE}
+	var unsupported []string
+	lenEncs := make(map[int16]int)
+	maxid := 0
+
+	for _, jf := range pkg.Subs {
+		unsupported = append(unsupported, fmt.Sprintf("field %s: sub-field packager %s", jf.ID, jposClassName(jf.Class)))
+	}
+	for _, jf := range pkg.Fields {
+		id, err := strconv.Atoi(jf.ID)
+		if err != nil || id < 0 || id > 192 {
+			return nil, nil, fmt.Errorf("jpos: invalid field id %q", jf.ID)
+		}
+		class := jposClassName(jf.Class)
+		switch {
+		case id == 0:
+			enc, ok := jposMTIClasses[class]
+			if !ok {
+				unsupported = append(unsupported, fmt.Sprintf("field 0: mti class %s", class))
+			} else {
+				spec.MsgType = enc
+			}
+			continue
+		case id == 1, id == 65 && strings.HasSuffix(class, "_BITMAP"):
+			enc, ok := jposBitmapClasses[class]
+			if !ok {
+				unsupported = append(unsupported, fmt.Sprintf("field %d: bitmap class %s", id, class))
+			} else if id == 1 {
+				spec.BitType = enc
+			}
+			continue
+		}
+
+		fd, err := jf.toFieldDef(class)
+		if err != nil {
+			unsupported = append(unsupported, fmt.Sprintf("field %d: %v", id, err))
+			continue
+		}
+		if fd.LenType != ISO_LEN_FIX {
+			lenEncs[fd.lenEnc(BCDTYPE)]++
+		}
+		for len(spec.Fields) < id {
+			spec.Fields = append(spec.Fields, IsoFieldDef{})
+		}
+		/*jPOS的name是描述,域名取标准名称*/
+		fd.Desc = fd.Name
+		if id <= len(isoFieldNames) {
+			fd.Name = isoFieldNames[id-1].name
+		}
+		spec.Fields[id-1] = fd
+		if id > maxid {
+			maxid = id
+		}
+	}
+	if maxid == 0 {
+		return nil, unsupported, fmt.Errorf("jpos: no usable field definitions")
+	}
+	spec.Fields[0] = IsoExDef{64, ISOLFIX | ISODBCD | ISOF0 | ISOLJUST}.FieldDef()
+
+	/*最常用的长度前缀编码作为默认值*/
+	best := 0
+	for enc, n := range lenEncs {
+		if n > best || (n == best && enc < spec.LenType) {
+			spec.LenType, best = enc, n
+		}
+	}
+	for i := range spec.Fields {
+		if spec.Fields[i].hasLenEnc() && spec.Fields[i].LenEnc == spec.LenType {
+			spec.Fields[i].LenEnc, spec.Fields[i].LenEncSet = LENDEFAULT, false
+		}
+	}
+	sort.Strings(unsupported)
+	return spec, unsupported, nil
+}
+
+func LoadJPOS(path string) (*Spec, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return ImportJPOS(f)
+}
+
+/*org.jpos.iso.IFB_LLNUM -> IFB_LLNUM*/
+func jposClassName(class string) string {
+	if i := strings.LastIndexByte(class, '.'); i >= 0 {
+		class = class[i+1:]
+	}
+	return strings.TrimSpace(class)
+}
+
+func (jf *jposField) toFieldDef(class string) (IsoFieldDef, error) {
+	jc, ok := jposClasses[class]
+	if !ok {
+		return IsoFieldDef{}, fmt.Errorf("unsupported class %s", class)
+	}
+	length, err := strconv.Atoi(jf.Length)
+	if err != nil || length <= 0 {
+		return IsoFieldDef{}, fmt.Errorf("invalid length %q", jf.Length)
+	}
+	fd := IsoFieldDef{
+		Name:       jf.Name,
+		Length:     length,
+		LenType:    jc.lenType,
+		LenEnc:     jc.lenEnc,
+		LenEncSet:  jc.lenType != ISO_LEN_FIX,
+		DataEnc:    jc.dataEnc,
+		LenInBytes: jc.inBytes,
+		Pad:        ' ',
+		PadSet:     true,
+	}
+	switch {
+	case jc.dataEnc == ISODBIN:
+		fd.Pad = 0x00
+	case jc.dataEnc == ISODC_D:
+		/*jPOS的长度含借贷记标志*/
+		fd.Length--
+		fd.Pad, fd.RightJust = '0', true
+	case jc.numeric && jc.lenType == ISO_LEN_FIX:
+		fd.Pad, fd.RightJust = '0', true
+	case jc.numeric:
+		/*变长BCD数字域:pad="true"时奇数位左补0,否则右补0*/
+		fd.Pad = '0'
+		fd.RightJust = jc.dataEnc == ISODBCD && jf.Pad == "true"
+	}
+	if fd.Length <= 0 {
+		return IsoFieldDef{}, fmt.Errorf("invalid length %q", jf.Length)
+	}
+	return fd, fd.check()
+}
@@ -0,0 +1,99 @@
+package iso8583
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testJPOSPackager = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE isopackager SYSTEM "genericpackager.dtd">
+<!-- ISO 8583:1987 binary packager (partial) -->
+<isopackager>
+  <isofield id="0" length="4" name="MESSAGE TYPE INDICATOR" pad="true" class="org.jpos.iso.IFB_NUMERIC"/>
+  <isofield id="1" length="16" name="BIT MAP" class="org.jpos.iso.IFB_BITMAP"/>
+  <isofield id="2" length="19" name="PAN - PRIMARY ACCOUNT NUMBER" pad="false" class="org.jpos.iso.IFB_LLNUM"/>
+  <isofield id="3" length="6" name="PROCESSING CODE" pad="true" class="org.jpos.iso.IFB_NUMERIC"/>
+  <isofield id="4" length="12" name="AMOUNT, TRANSACTION" pad="true" class="org.jpos.iso.IFB_NUMERIC"/>
+  <isofield id="28" length="9" name="AMOUNT, TRANSACTION FEE" class="org.jpos.iso.IFA_AMOUNT"/>
+  <isofield id="35" length="37" name="TRACK 2 DATA" pad="true" class="org.jpos.iso.IFB_LLNUM"/>
+  <isofield id="41" length="8" name="CARD ACCEPTOR TERMINAL IDENTIFICACION" class="org.jpos.iso.IF_CHAR"/>
+  <isofield id="44" length="25" name="ADITIONAL RESPONSE DATA" class="org.jpos.iso.IFA_LLCHAR"/>
+  <isofield id="46" length="999" name="ADITIONAL DATA - ISO" class="org.jpos.iso.IFB_LLLCHAR"/>
+  <isofield id="48" length="999" name="ADITIONAL DATA - PRIVATE" class="org.jpos.iso.IFB_LLLHCHAR"/>
+  <isofield id="52" length="8" name="PIN DATA" class="org.jpos.iso.IFB_BINARY"/>
+  <isofieldpackager id="62" length="999" name="PRIVATE USE" class="org.jpos.iso.IFB_LLLBINARY"/>
+  <isofield id="63" length="999" name="RESERVED PRIVATE" class="org.jpos.iso.IFB_AMOUNT"/>
+  <isofield id="64" length="8" name="MESSAGE AUTHENTICATION CODE FIELD" class="org.jpos.iso.IFA_BINARY"/>
+  <isofield id="70" length="3" name="NETWORK MANAGEMENT INFORMATION CODE" pad="true" class="org.jpos.iso.IFB_NUMERIC"/>
+</isopackager>
+`
+
+func TestImportJPOS(t *testing.T) {
+	spec, unsupported, err := ImportJPOS(strings.NewReader(testJPOSPackager))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"field 62: sub-field packager IFB_LLLBINARY",
+		"field 63: unsupported class IFB_AMOUNT",
+		"field 64: unsupported class IFA_BINARY",
+	}
+	if strings.Join(unsupported, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("unsupported %q", unsupported)
+	}
+	if spec.MsgType != BCDTYPE || spec.BitType != BCDTYPE || spec.LenType != BCDTYPE || len(spec.Fields) != 70 {
+		t.Fatalf("spec %d %d %d %d", spec.MsgType, spec.BitType, spec.LenType, len(spec.Fields))
+	}
+
+	f := spec.Fields
+	if f[1].Name != "PAN" || f[1].Desc != "PAN - PRIMARY ACCOUNT NUMBER" || f[1].LenType != ISO_LEN_VAR2 || f[1].DataEnc != ISODBCD || f[1].RightJust {
+		t.Fatalf("field 2 %+v", f[1])
+	}
+	if !f[34].RightJust || !f[3].RightJust || f[3].Pad != '0' {
+		t.Fatal("numeric field not right justified")
+	}
+	if f[27].DataEnc != ISODC_D || f[27].Length != 8 {
+		t.Fatalf("field 28 %+v", f[27])
+	}
+	if f[40].Pad != ' ' || f[40].RightJust || f[43].LenEnc != ASCTYPE || f[45].LenEnc != LENDEFAULT || f[47].LenEnc != BINTYPE {
+		t.Fatal("char field definition")
+	}
+	if f[51].DataEnc != ISODBIN || !f[51].LenInBytes || f[51].Length != 8 {
+		t.Fatalf("field 52 %+v", f[51])
+	}
+	if f[61].defined() || f[62].defined() || f[63].defined() {
+		t.Fatal("unsupported field defined")
+	}
+
+	iso, err := NewIsoExSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso.SetMTI("0800")
+	iso.SetField(2, []byte("622202123"))
+	iso.SetField(41, []byte("T1"))
+	iso.SetField(44, []byte("OK"))
+	iso.SetField(48, []byte("AB"))
+	iso.SetField(70, []byte("1"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectData := "\x08\x00" + "\xc0\x00\x00\x00\x00\x91\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00" +
+		"\x09\x62\x22\x02\x12\x30" + "T1      " + "02OK" + "\x00\x02AB" + "\x00\x01"
+	if string(data) != expectData {
+		t.Fatalf("Iso2StrEx [% x]", data)
+	}
+	iso2, _ := NewIsoExSpec(spec)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(iso2.GetField(2), []byte("622202123")) || !bytes.Equal(iso2.GetField(70), []byte("001")) {
+		t.Fatal("Str2IsoEx field value")
+	}
+
+	if _, _, err = ImportJPOS(strings.NewReader(`<isopackager><isofield id="x" length="1" class="IF_CHAR"/></isopackager>`)); err == nil {
+		t.Fatal("ImportJPOS accept bad field id")
+	}
+}
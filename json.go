@@ -0,0 +1,138 @@
+package iso8583
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonHexPrefix    = "hex:"
+	jsonBase64Prefix = "base64:"
+)
+
+/*
+MarshalJSON 输出 {"header":"6000010002","mti":"0200","bitmaps":2,"fields":{"2":"...","52":"hex:..."}},域按域号排序.
+header为报文头的十六进制,没有报文头时不输出;bitmaps为解析时收到的位图个数(含空的扩展位图),只有主位图或新建的报文不输出.
+二进制域、含不可打印字符的域以及本身以"hex:"/"base64:"开头的域值编码为"hex:"(或"base64:")加编码后的数据,
+UnmarshalJSON 按同一规范还原,再经Iso2StrEx得到相同的报文.
+*/
+func (iso *IsoEx) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	mti, _ := json.Marshal(iso.MTI())
+	buf.WriteByte('{')
+	if iso.header != nil {
+		fmt.Fprintf(&buf, `"header":"%X",`, iso.header)
+	}
+	buf.WriteString(`"mti":`)
+	buf.Write(mti)
+	if iso.bitmaps > 1 {
+		fmt.Fprintf(&buf, `,"bitmaps":%d`, iso.bitmaps)
+	}
+	buf.WriteString(`,"fields":{`)
+	first := true
+	for i := 1; i < len(iso.field); i++ {
+		if iso.field[i].bitflag == 0 {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		value, _ := json.Marshal(iso.jsonValue(i, iso.field[i].data))
+		fmt.Fprintf(&buf, `"%d":`, i+1)
+		buf.Write(value)
+	}
+	buf.WriteString("}}")
+	return buf.Bytes(), nil
+}
+
+func (iso *IsoEx) jsonValue(bitno int, data []byte) string {
+	raw := iso.fdef[bitno].DataEnc == ISODBIN || !isPrintable(data) ||
+		bytes.HasPrefix(data, []byte(jsonHexPrefix)) || bytes.HasPrefix(data, []byte(jsonBase64Prefix))
+	switch {
+	case !raw:
+		return string(data)
+	case iso.jsonb64:
+		return jsonBase64Prefix + base64.StdEncoding.EncodeToString(data)
+	case iso.hexlower:
+		return jsonHexPrefix + hex.EncodeToString(data)
+	}
+	return jsonHexPrefix + strings.ToUpper(hex.EncodeToString(data))
+}
+
+/*域值按报文原有的规范设置,解析失败时报文不变*/
+func (iso *IsoEx) UnmarshalJSON(data []byte) error {
+	var doc struct {
+		Header  *string           `json:"header"`
+		MTI     string            `json:"mti"`
+		Bitmaps int               `json:"bitmaps"`
+		Fields  map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	tmp := *iso
+	tmp.field = nil
+	tmp.header = nil
+	if doc.Header != nil {
+		header, err := hex.DecodeString(*doc.Header)
+		if err != nil {
+			return fmt.Errorf("header: %v", err)
+		}
+		if err = tmp.SetHeader(header); err != nil {
+			return err
+		}
+	}
+	if doc.Bitmaps < 0 || doc.Bitmaps > iso.maxBitmapLen()/8 {
+		return fmt.Errorf("invalid bitmaps %d", doc.Bitmaps)
+	}
+	tmp.bitmaps = doc.Bitmaps
+	if err := tmp.SetMTI(doc.MTI); err != nil {
+		return err
+	}
+	tmp.allocField(tmp.bitmaps * 64)
+	nums := make([]int, 0, len(doc.Fields))
+	for key := range doc.Fields {
+		n, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("invalid field number %q", key)
+		}
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	for _, n := range nums {
+		s := doc.Fields[strconv.Itoa(n)]
+		var value []byte
+		var err error
+		switch {
+		case strings.HasPrefix(s, jsonHexPrefix):
+			value, err = hex.DecodeString(s[len(jsonHexPrefix):])
+		case strings.HasPrefix(s, jsonBase64Prefix):
+			value, err = base64.StdEncoding.DecodeString(s[len(jsonBase64Prefix):])
+		default:
+			value = []byte(s)
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %v", n, err)
+		}
+		if err = tmp.SetField(n, value); err != nil {
+			return err
+		}
+	}
+	iso.field = tmp.field
+	iso.header = tmp.header
+	iso.bitmaps = tmp.bitmaps
+	return nil
+}
+
+/*MarshalJSON对需要编码的域使用base64,默认为十六进制*/
+func (iso *IsoEx) SetJSONBase64(b64 bool) {
+	iso.jsonb64 = b64
+}
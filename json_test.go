@@ -0,0 +1,112 @@
+package iso8583
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestIsoExJSON(t *testing.T) {
+	iso, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(41, []byte("T01"))
+	iso.SetField(44, []byte("a\"b\x01"))
+	iso.SetField(47, []byte("hex:abc"))
+	iso.SetField(52, []byte{0x12, 0x34, 0xab, 0xcd, 0, 0, 0, 0xff})
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iso2, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	if err = iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	js, err := json.Marshal(iso2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"mti":"0200","fields":{"2":"6222020000001234","41":"T01     ","44":"hex:61226201","47":"hex:6865783A616263","52":"hex:1234ABCD000000FF"}}`
+	if string(js) != expect {
+		t.Fatalf("MarshalJSON %s", js)
+	}
+
+	iso3, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	if err = json.Unmarshal(js, iso3); err != nil {
+		t.Fatal(err)
+	}
+	data3, _ := iso3.Iso2StrEx()
+	if !bytes.Equal(data, data3) {
+		t.Fatal("Compare data and data3 failed")
+	}
+
+	iso3.SetJSONBase64(true)
+	js, _ = json.Marshal(iso3)
+	iso4, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	if err = json.Unmarshal(js, iso4); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(iso4.GetField(52), iso.GetField(52)) || !bytes.Contains(js, []byte(`"52":"base64:EjSrzQAAAP8="`)) {
+		t.Fatalf("base64 %s", js)
+	}
+
+	for _, bad := range []string{
+		`{"mti":"02000","fields":{}}`,
+		`{"mti":"0200","fields":{"x":"1"}}`,
+		`{"mti":"0200","fields":{"52":"hex:zz"}}`,
+		`{"mti":"0200","fields":{"1":"1"}}`,
+	} {
+		if err = json.Unmarshal([]byte(bad), iso4); err == nil {
+			t.Fatalf("UnmarshalJSON accept %s", bad)
+		}
+	}
+	if !bytes.Equal(iso4.GetField(52), iso.GetField(52)) {
+		t.Fatal("UnmarshalJSON changed message on error")
+	}
+}
+
+/*报文头与收到的空第二位图经JSON还原后,打包得到与收到的相同报文*/
+func TestIsoExJSONWire(t *testing.T) {
+	iso := newTestMsg("0800", "000001")
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	/*TPDU(5字节)+报文类型(2字节)之后是主位图,加一个空的第二位图*/
+	head := TPDU_LEN + 2
+	wire := append([]byte(nil), data[:head+8]...)
+	wire[head] |= 0x80
+	wire = append(wire, make([]byte, 8)...)
+	wire = append(wire, data[head+8:]...)
+
+	iso2 := newTestMsg("0800", "000000")
+	iso2.Reset()
+	if err = iso2.Str2IsoEx(wire); err != nil {
+		t.Fatal(err)
+	}
+	js, _ := json.Marshal(iso2)
+	if !bytes.HasPrefix(js, []byte(`{"header":"6000010002","mti":"0800","bitmaps":2,`)) {
+		t.Fatalf("MarshalJSON %s", js)
+	}
+	iso3 := newTestMsg("0800", "000000")
+	iso3.Reset()
+	iso3.SetTPDU(TPDU{0x60, 0x1234, 0x5678})
+	if err = json.Unmarshal(js, iso3); err != nil {
+		t.Fatal(err)
+	}
+	data3, _ := iso3.Iso2StrEx()
+	if !bytes.Equal(data3, wire) {
+		t.Fatalf("Iso2StrEx [% x], want [% x]", data3, wire)
+	}
+
+	for _, bad := range []string{
+		`{"header":"60","mti":"0800","fields":{}}`,
+		`{"header":"zz","mti":"0800","fields":{}}`,
+		`{"mti":"0800","bitmaps":3,"fields":{}}`,
+	} {
+		if err = json.Unmarshal([]byte(bad), iso3); err == nil {
+			t.Errorf("UnmarshalJSON accept %s", bad)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package iso8583
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*工作密钥块中每个密钥后的校验值长度*/
+const KEY_BLOCK_KCV_LEN = 4
+
+/*工作密钥块中密钥的顺序*/
+var keyBlockTypes = []string{KEY_PIK, KEY_MAK, KEY_TDK}
+
+/*
+ParseKeyBlock 解析签到应答中的工作密钥块(银联第62域,部分主机为第48域):
+PIK、MAK、TDK依次为TMK下的密文加4字节校验值.
+24/36字节为单倍长密钥,40/60字节为双倍长,双倍长块中后8字节为0的MAK为单倍长.
+返回的密钥名称为prefix加密钥类型.
+*/
+func ParseKeyBlock(data []byte, prefix string) ([]WrappedKey, error) {
+	var size int
+	switch len(data) {
+	case 24, 36:
+		size = 8
+	case 40, 60:
+		size = 16
+	default:
+		return nil, fmt.Errorf("bad key block length %d", len(data))
+	}
+	var keys []WrappedKey
+	for i := 0; len(data) > 0; i++ {
+		key := data[:size]
+		if size == 16 && keyBlockTypes[i] == KEY_MAK && bytes.Equal(key[8:], make([]byte, 8)) {
+			key = key[:8]
+		}
+		keys = append(keys, WrappedKey{
+			Name: prefix + keyBlockTypes[i],
+			Type: keyBlockTypes[i],
+			Key:  append([]byte(nil), key...),
+			KCV:  append([]byte(nil), data[size:size+KEY_BLOCK_KCV_LEN]...),
+		})
+		data = data[size+KEY_BLOCK_KCV_LEN:]
+	}
+	return keys, nil
+}
+
+/*BuildKeyBlock 按PIK、MAK、TDK的顺序生成工作密钥块,keys的类型须依次对应,TDK可省略*/
+func BuildKeyBlock(keys ...WrappedKey) ([]byte, error) {
+	if len(keys) < 2 || len(keys) > 3 {
+		return nil, fmt.Errorf("key block needs 2 or 3 keys, got %d", len(keys))
+	}
+	size := 8
+	for i, k := range keys {
+		if k.Type != keyBlockTypes[i] {
+			return nil, fmt.Errorf("key block position %d is %s, got %s", i+1, keyBlockTypes[i], k.Type)
+		}
+		if len(k.Key) != 8 && len(k.Key) != 16 {
+			return nil, fmt.Errorf("key %s length %d", k.Name, len(k.Key))
+		}
+		if len(k.KCV) < KEY_BLOCK_KCV_LEN {
+			return nil, fmt.Errorf("key %s kcv too short", k.Name)
+		}
+		if len(k.Key) == 16 {
+			size = 16
+		}
+	}
+	var data []byte
+	for _, k := range keys {
+		if len(k.Key) != size && k.Type != KEY_MAK {
+			return nil, fmt.Errorf("key %s is single length in a double length block", k.Name)
+		}
+		slot := make([]byte, size)
+		copy(slot, k.Key)
+		data = append(data, slot...)
+		data = append(data, k.KCV[:KEY_BLOCK_KCV_LEN]...)
+	}
+	return data, nil
+}
+
+/*
+ImportKeyBlock 在TMK下解密工作密钥块并校验KCV,全部通过后新密钥一起生效,
+之后的MAC与PIN运算(SetMACKey、SetPINKey)使用新密钥;失败时原密钥不变.
+*/
+func ImportKeyBlock(h HSM, tmk string, data []byte, prefix string) error {
+	keys, err := ParseKeyBlock(data, prefix)
+	if err != nil {
+		return err
+	}
+	return h.ImportKeys(tmk, keys...)
+}
+
+/*ExportKeyBlock 主机端:在TMK下导出PIK、MAK及可选的TDK,生成工作密钥块*/
+func ExportKeyBlock(h HSM, tmk string, pik, mak, tdk string) ([]byte, error) {
+	names := []string{pik, mak}
+	if tdk != "" {
+		names = append(names, tdk)
+	}
+	keys := make([]WrappedKey, 0, len(names))
+	for i, name := range names {
+		k, err := h.ExportKey(name, tmk)
+		if err != nil {
+			return nil, err
+		}
+		if k.Type != keyBlockTypes[i] {
+			return nil, fmt.Errorf("key %s is %s, want %s", name, k.Type, keyBlockTypes[i])
+		}
+		keys = append(keys, k)
+	}
+	return BuildKeyBlock(keys...)
+}
+
+/*
+KeyExchangeHandler 从报文的field域导入工作密钥,用于SessionConfig的OnSignOn与OnKeyChange.
+报文中没有该域时不更换密钥.
+*/
+func KeyExchangeHandler(h HSM, tmk string, field int, prefix string) func(iso *IsoEx) error {
+	return func(iso *IsoEx) error {
+		data := iso.GetField(field)
+		if data == nil {
+			return nil
+		}
+		if err := ImportKeyBlock(h, tmk, data, prefix); err != nil {
+			return fmt.Errorf("field %d: %w", field, err)
+		}
+		return nil
+	}
+}
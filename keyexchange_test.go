@@ -0,0 +1,116 @@
+package iso8583
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newKeyHSMs(t *testing.T) (*SoftHSM, *SoftHSM) {
+	lmk := mustHex("00112233445566778899AABBCCDDEEFF")
+	host, _ := NewSoftHSM(lmk, "")
+	term, _ := NewSoftHSM(lmk, "")
+	tmk := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	if _, err := host.SetKey("T0000001/TMK", KEY_TMK, tmk); err != nil {
+		t.Fatal(err)
+	}
+	term.SetKey("TMK", KEY_TMK, tmk)
+	return host, term
+}
+
+func TestKeyBlockDouble(t *testing.T) {
+	host, term := newKeyHSMs(t)
+	host.GenerateKey("T0000001/PIK", KEY_PIK, 16)
+	host.GenerateKey("T0000001/MAK", KEY_MAK, 8)
+	host.GenerateKey("T0000001/TDK", KEY_TDK, 16)
+	data, err := ExportKeyBlock(host, "T0000001/TMK", "T0000001/PIK", "T0000001/MAK", "T0000001/TDK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 60 || !bytes.Equal(data[28:36], make([]byte, 8)) {
+		t.Fatalf("key block % X", data)
+	}
+	keys, err := ParseKeyBlock(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[1].Name != KEY_MAK || len(keys[1].Key) != 8 || len(keys[2].Key) != 16 {
+		t.Fatalf("parsed keys %+v", keys)
+	}
+	if err = ImportKeyBlock(term, "TMK", data, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range keyBlockTypes {
+		want, _ := host.KCV("T0000001/" + typ)
+		got, _ := term.KCV(typ)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s kcv %X, want %X", typ, got, want)
+		}
+	}
+	if _, err = ExportKeyBlock(host, "T0000001/TMK", "T0000001/MAK", "T0000001/PIK", ""); err == nil {
+		t.Error("key block with keys out of order")
+	}
+	if _, err = ParseKeyBlock(data[:50], ""); err == nil {
+		t.Error("bad key block length accepted")
+	}
+}
+
+func TestKeyExchangeSignOn(t *testing.T) {
+	host, term := newKeyHSMs(t)
+	host.GenerateKey("T0000001/PIK", KEY_PIK, 8)
+	host.GenerateKey("T0000001/MAK", KEY_MAK, 8)
+
+	/*主机签到应答的第62域携带单倍长的工作密钥(YL定义最长24字节)*/
+	rsp := newTestMsg("0810", "000001")
+	data, err := ExportKeyBlock(host, "T0000001/TMK", "T0000001/PIK", "T0000001/MAK", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rsp.SetField(62, data); err != nil {
+		t.Fatal(err)
+	}
+	packed, err := rsp.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rx := newTestMsg("0810", "000000")
+	rx.Reset()
+	if err = rx.Str2IsoEx(packed); err != nil {
+		t.Fatal(err)
+	}
+	onsignon := KeyExchangeHandler(term, "TMK", 62, "")
+	if err = onsignon(rx); err != nil {
+		t.Fatal(err)
+	}
+
+	/*终端用新密钥计算的MAC,主机可以校验*/
+	req := newTestMsg("0200", "000002")
+	req.SetMACKey(term, MAC_X99, KEY_MAK)
+	packed, err = req.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := newTestMsg("0200", "000000")
+	check.Reset()
+	check.SetMACKey(host, MAC_X99, "T0000001/MAK")
+	if err = check.Str2IsoEx(packed); err != nil {
+		t.Fatal(err)
+	}
+
+	/*校验值错误时不更换密钥*/
+	old, _ := term.KCV(KEY_MAK)
+	host.GenerateKey("T0000001/MAK", KEY_MAK, 8)
+	data, _ = ExportKeyBlock(host, "T0000001/TMK", "T0000001/PIK", "T0000001/MAK", "")
+	data[len(data)-1] ^= 0xff
+	rx.SetField(62, data)
+	if err = onsignon(rx); !errors.Is(err, ErrBadKCV) {
+		t.Fatalf("bad kcv: %v", err)
+	}
+	if cur, _ := term.KCV(KEY_MAK); !bytes.Equal(cur, old) {
+		t.Error("mak replaced by a key that failed verification")
+	}
+	rx.UnsetField(62)
+	if err = onsignon(rx); err != nil {
+		t.Errorf("no key block: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package iso8583
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+var globalLogger atomic.Pointer[slog.Logger]
+
+/*全局日志,未单独设置日志的IsoEx使用;nil关闭日志(默认)*/
+func SetLogger(l *slog.Logger) {
+	globalLogger.Store(l)
+}
+
+/*本报文的日志,优先于全局日志;用于只跟踪某个连接*/
+func (iso *IsoEx) SetLogger(l *slog.Logger) {
+	iso.logger = l
+}
+
+func (iso *IsoEx) log() *slog.Logger {
+	if iso.logger != nil {
+		return iso.logger
+	}
+	return globalLogger.Load()
+}
+
+/*设置跟踪日志的脱敏策略,nil为DefaultMask;与SetMask分开,Describe不脱敏时日志仍脱敏*/
+func (iso *IsoEx) SetLogMask(mask MaskFunc) {
+	iso.logmask = mask
+}
+
+func (iso *IsoEx) logMaskValue(bitno int, value []byte) []byte {
+	if iso.logmask == nil {
+		return DefaultMask(bitno, value)
+	}
+	return iso.logmask(bitno, value)
+}
+
+/*打解包的跟踪日志为Debug级别,未开启时不做格式化*/
+func (iso *IsoEx) tracing() *slog.Logger {
+	l := iso.log()
+	if l == nil || !l.Enabled(context.Background(), slog.LevelDebug) {
+		return nil
+	}
+	return l
+}
+
+/*域值按SetLogMask的策略脱敏后记录,二进制或不可打印的值记为十六进制*/
+func (iso *IsoEx) traceField(msg string, bitno int, offset int, length int, value []byte) {
+	l := iso.tracing()
+	if l == nil {
+		return
+	}
+	if iso.fdef[bitno].DataEnc == ISODBIN || !isPrintable(value) {
+		value = []byte(hex.EncodeToString(value))
+	}
+	l.Debug(msg,
+		slog.Int("field", bitno+1),
+		slog.Int("offset", offset),
+		slog.Int("length", length),
+		slog.String("value", string(iso.logMaskValue(bitno+1, value))))
+}
+
+/*
+DumpHex 记录数据的长度.报文中可能有主账号、PIN等敏感数据,不记录内容;
+需要查看报文时使用SetLogger,各域脱敏后记录.
+
+Deprecated: 使用SetLogger设置日志.
+*/
+func DumpHex(data []byte) error {
+	if l := globalLogger.Load(); l != nil && l.Enabled(context.Background(), slog.LevelDebug) {
+		l.Debug("dump", slog.Int("length", len(data)))
+	}
+	return nil
+}
+
+/*
+Debug 记录格式化的调试信息.
+
+Deprecated: 使用SetLogger设置日志,信息记录到全局日志的Debug级别.
+*/
+func Debug(format string, a ...interface{}) error {
+	if l := globalLogger.Load(); l != nil && l.Enabled(context.Background(), slog.LevelDebug) {
+		l.Debug(fmt.Sprintf(format, a...))
+	}
+	return nil
+}
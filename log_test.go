@@ -0,0 +1,73 @@
+package iso8583
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	iso, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso.SetMTI("0200")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(41, []byte("T01"))
+	data, _ := iso.Iso2StrEx()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	iso2, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	iso2.SetLogger(logger)
+	if err := iso2.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"msg=unpack length=27",
+		"msg=\"unpack header\" mti=0200 bitmap=4000000000800000",
+		"msg=\"unpack field\" field=2 offset=10 length=9 value=6222********1234",
+		"msg=\"unpack field\" field=41 offset=19 length=8 value=\"T01     \"",
+	} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("log missing %s\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "6222020000001234") {
+		t.Fatal("PAN not masked")
+	}
+
+	/*Describe不脱敏时日志仍脱敏*/
+	buf.Reset()
+	iso2.SetMask(NoMask)
+	iso2.Iso2StrEx()
+	if strings.Contains(buf.String(), "6222020000001234") || !strings.Contains(buf.String(), "value=6222********1234") {
+		t.Fatalf("log mask follows describe mask\n%s", buf.String())
+	}
+	buf.Reset()
+	iso2.SetLogMask(NoMask)
+	iso2.Iso2StrEx()
+	if !strings.Contains(buf.String(), "value=6222020000001234") {
+		t.Fatalf("log mask\n%s", buf.String())
+	}
+
+	/*全局日志,Info级别不记录跟踪*/
+	buf.Reset()
+	SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer SetLogger(nil)
+	iso.Iso2StrEx()
+	if buf.Len() != 0 {
+		t.Fatalf("trace logged at info level: %s", buf.String())
+	}
+	SetLogger(logger)
+	iso.Iso2StrEx()
+	if !strings.Contains(buf.String(), "msg=\"pack field\" field=41 offset=19 length=8") || !strings.Contains(buf.String(), "msg=pack mti=0200 length=27") {
+		t.Fatalf("global log\n%s", buf.String())
+	}
+
+	/*DumpHex不记录报文内容*/
+	buf.Reset()
+	DumpHex(data)
+	if !strings.Contains(buf.String(), "msg=dump length=27") || strings.Contains(buf.String(), "622202") {
+		t.Fatalf("DumpHex\n%s", buf.String())
+	}
+}
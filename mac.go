@@ -0,0 +1,200 @@
+package iso8583
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+)
+
+/*MAC算法*/
+const MAC_X99 = 1       /*ANSI X9.9,单DES CBC*/
+const MAC_X919 = 2      /*ANSI X9.19零售MAC,双倍长密钥*/
+const MAC_ISO9797_1 = 3 /*ISO 9797-1算法1,CBC,按密钥长度使用DES或3DES*/
+const MAC_ISO9797_3 = 4 /*ISO 9797-1算法3,同X9.19*/
+const MAC_CUP_ECB = 5   /*银联POS ECB:各块异或后两次加密,结果为8个十六进制字符*/
+const MAC_LEN = 8
+
+/*8字节为DES,16字节为双倍长3DES(K1K2K1),24字节为三倍长3DES*/
+func desCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 8:
+		return des.NewCipher(key)
+	case 16:
+		return des.NewTripleDESCipher(append(append([]byte(nil), key...), key[:8]...))
+	case 24:
+		return des.NewTripleDESCipher(key)
+	}
+	return nil, fmt.Errorf("bad des key length %d", len(key))
+}
+
+/*填充方法1:补0到8的倍数,空数据补一个全0块*/
+func macPad(data []byte) []byte {
+	n := (len(data) + 7) / 8 * 8
+	if n == 0 {
+		n = 8
+	}
+	buf := make([]byte, n)
+	copy(buf, data)
+	return buf
+}
+
+func xorBlock(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func cbcMAC(block cipher.Block, data []byte) []byte {
+	mac := make([]byte, 8)
+	for i := 0; i < len(data); i += 8 {
+		xorBlock(mac, data[i:i+8])
+		block.Encrypt(mac, mac)
+	}
+	return mac
+}
+
+/*ComputeMAC 计算data的MAC,结果8字节*/
+func ComputeMAC(alg int, key []byte, data []byte) ([]byte, error) {
+	data = macPad(data)
+	switch alg {
+	case MAC_X99:
+		if len(key) != 8 {
+			return nil, fmt.Errorf("x9.9 key length %d, need 8", len(key))
+		}
+		block, err := des.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cbcMAC(block, data), nil
+	case MAC_ISO9797_1:
+		block, err := desCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cbcMAC(block, data), nil
+	case MAC_X919, MAC_ISO9797_3:
+		if len(key) != 16 {
+			return nil, fmt.Errorf("x9.19 key length %d, need 16", len(key))
+		}
+		k1, _ := des.NewCipher(key[:8])
+		k2, _ := des.NewCipher(key[8:])
+		mac := cbcMAC(k1, data)
+		k2.Decrypt(mac, mac)
+		k1.Encrypt(mac, mac)
+		return mac, nil
+	case MAC_CUP_ECB:
+		block, err := desCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := make([]byte, 8)
+		for i := 0; i < len(data); i += 8 {
+			xorBlock(sum, data[i:i+8])
+		}
+		text := []byte(fmt.Sprintf("%X", sum))
+		mac := make([]byte, 8)
+		block.Encrypt(mac, text[:8])
+		xorBlock(mac, text[8:])
+		block.Encrypt(mac, mac)
+		return []byte(fmt.Sprintf("%X", mac)[:8]), nil
+	}
+	return nil, fmt.Errorf("unsupported mac algorithm %d", alg)
+}
+
+/*
+SetMAC 设置MAC算法与密钥:Iso2StrEx自动计算并设置MAC域,Str2IsoEx收到MAC域时校验.
+MAC域为最后一个域(只有主位图时为64域,否则为128域),计算范围为报文类型至MAC域之前,
+不含报文头;收到的报文在其他位置带64/128域时返回ErrMACPosition,按无法解析的报文丢弃.key为nil时关闭.
+*/
+func (iso *IsoEx) SetMAC(alg int, key []byte) error {
+	if key == nil {
+		iso.macfn = nil
+		return nil
+	}
+	if _, err := ComputeMAC(alg, key, nil); err != nil {
+		return err
+	}
+	key = append([]byte(nil), key...)
+	iso.macfn = func(data []byte) ([]byte, error) {
+		return ComputeMAC(alg, key, data)
+	}
+	return nil
+}
+
+/*MAC域须为定长8字节二进制*/
+func (iso *IsoEx) checkMACField(n int) error {
+	if err := iso.checkFieldNo(n); err != nil {
+		return err
+	}
+	def := &iso.fdef[n-1]
+	if def.LenType != ISO_LEN_FIX || def.DataEnc != ISODBIN || def.wireLen(def.Length) != MAC_LEN {
+		return fmt.Errorf("field %d is not a 64-bit binary mac field", n)
+	}
+	return nil
+}
+
+func (iso *IsoEx) packMAC() ([]byte, error) {
+	for _, m := range []int{64, 128, 192} {
+		iso.UnsetField(m)
+	}
+	n := iso.Bitmaps() * 64
+	if err := iso.checkMACField(n); err != nil {
+		return nil, err
+	}
+	if err := iso.SetField(n, make([]byte, MAC_LEN)); err != nil {
+		return nil, err
+	}
+	data, err := iso.pack()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := iso.macfn(data[iso.headerlen : len(data)-MAC_LEN])
+	if err != nil {
+		return nil, err
+	}
+	copy(data[len(data)-MAC_LEN:], mac)
+	iso.field[n-1].data = mac
+	return data, nil
+}
+
+/*start为MAC域在报文(不含报文头)中的偏移*/
+func (iso *IsoEx) verifyMAC(n int, start int) error {
+	if err := iso.checkMACField(n); err != nil {
+		return err
+	}
+	mac, err := iso.macfn(iso.buffer[:start])
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(mac, iso.field[n-1].data) != 1 {
+		if l := iso.tracing(); l != nil {
+			l.Debug("mac mismatch", slog.Int("field", n), slog.String("expected", hex.EncodeToString(mac)))
+		}
+		return &ParseError{Field: n, Offset: start, Err: ErrBadMAC}
+	}
+	return nil
+}
+
+/*
+RequireMAC 服务端中间件:请求没有经Str2IsoEx校验通过的MAC域时应答A0(MAC校验错),
+不调用处理函数,因此Server的原型报文须设置MAC密钥.MAC错误的请求由Server直接应答A0.
+*/
+func RequireMAC() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+			if req.macfield != 0 && req.HasField(req.macfield) {
+				return next(ctx, req)
+			}
+			rsp, err := req.NewEchoResponse()
+			if err != nil {
+				return nil, err
+			}
+			rsp.SetField(39, []byte("A0"))
+			return rsp, fmt.Errorf("mti %s without mac", req.MTI())
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package iso8583
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeMAC(t *testing.T) {
+	data := []byte("7654321 Now is the time for ")
+	k1 := mustHex("0123456789ABCDEF")
+	k2 := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	tests := []struct {
+		alg  int
+		key  []byte
+		want string
+	}{
+		{MAC_X99, k1, "F1D30F6849312CA4"},
+		{MAC_ISO9797_1, k1, "F1D30F6849312CA4"},
+		{MAC_X919, k2, "AE4B45B1B527642F"},
+		{MAC_ISO9797_3, k2, "AE4B45B1B527642F"},
+	}
+	for _, tt := range tests {
+		mac, err := ComputeMAC(tt.alg, tt.key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(mac); got != strings.ToLower(tt.want) {
+			t.Errorf("alg %d: %s, want %s", tt.alg, got, tt.want)
+		}
+	}
+	mac, err := ComputeMAC(MAC_CUP_ECB, k1, data)
+	if err != nil || string(mac) != "04F42534" {
+		t.Errorf("cup ecb: %s %v", mac, err)
+	}
+	if _, err = ComputeMAC(MAC_X919, k1, data); err == nil {
+		t.Error("x9.19 with single length key")
+	}
+	if _, err = ComputeMAC(9, k1, data); err == nil {
+		t.Error("unknown algorithm")
+	}
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestMACPackUnpack(t *testing.T) {
+	key := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	iso := newTestMsg("0200", "000001")
+	iso.SetField(3, []byte("000000"))
+	iso.SetField(4, []byte("000000010000"))
+	if err := iso.SetMAC(MAC_X919, key[:8]); err == nil {
+		t.Error("x9.19 accepted 8 byte key")
+	}
+	iso.SetMAC(MAC_X919, key)
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := ComputeMAC(MAC_X919, key, data[TPDU_LEN:len(data)-8])
+	if string(iso.GetField(64)) != string(mac) || string(data[len(data)-8:]) != string(mac) {
+		t.Fatalf("field 64 %X, want %X", iso.GetField(64), mac)
+	}
+
+	rx := newTestMsg("0200", "000000")
+	rx.Reset()
+	rx.SetMAC(MAC_X919, key)
+	if err = rx.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-9] ^= 0x01
+	err = rx.Str2IsoEx(bad)
+	var pe *ParseError
+	if !errors.Is(err, ErrBadMAC) || !errors.As(err, &pe) || pe.Field != 64 {
+		t.Fatalf("tampered message: %v", err)
+	}
+	/*没有设置密钥时不校验*/
+	rx.SetMAC(0, nil)
+	if err = rx.Str2IsoEx(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	/*有扩展位图时使用第128域*/
+	iso.SetField(70, []byte("301"))
+	if data, err = iso.Iso2StrEx(); err != nil {
+		t.Fatal(err)
+	}
+	if iso.HasField(64) || !iso.HasField(128) {
+		t.Fatal("mac not moved to field 128")
+	}
+	rx.SetMAC(MAC_X919, key)
+	if err = rx.Str2IsoEx(data); err != nil || string(rx.GetField(70)) != "301" {
+		t.Fatalf("field 128: %v", err)
+	}
+}
+
+func TestRequireMAC(t *testing.T) {
+	h := RequireMAC()(func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		rsp, _ := req.NewEchoResponse()
+		rsp.SetField(39, []byte("00"))
+		return rsp, nil
+	})
+	key := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	req := newTestMsg("0200", "000001")
+	rsp, err := h(context.Background(), req)
+	if err == nil || string(rsp.GetField(39)) != "A0" {
+		t.Errorf("without mac: %v", err)
+	}
+	/*没有经过校验的MAC域不算*/
+	req.SetField(64, make([]byte, 8))
+	if rsp, err = h(context.Background(), req); err == nil || string(rsp.GetField(39)) != "A0" {
+		t.Errorf("unverified mac: %v", err)
+	}
+	req.SetMAC(MAC_X919, key)
+	data, err := req.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rx := newTestMsg("0200", "000000")
+	rx.Reset()
+	rx.SetMAC(MAC_X919, key)
+	if err = rx.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err = h(context.Background(), rx); err != nil || string(rsp.GetField(39)) != "00" {
+		t.Errorf("with mac: %v", err)
+	}
+}
+
+func TestMACPosition(t *testing.T) {
+	key := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	/*有扩展位图时64域全0、没有128域:不能绕过校验*/
+	iso := newTestMsg("0200", "000001")
+	iso.SetField(64, make([]byte, 8))
+	iso.SetField(70, []byte("301"))
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rx := newTestMsg("0200", "000000")
+	rx.Reset()
+	rx.SetMAC(MAC_X919, key)
+	err = rx.Str2IsoEx(data)
+	var pe *ParseError
+	if !errors.Is(err, ErrMACPosition) || errors.Is(err, ErrBadMAC) || !errors.As(err, &pe) || pe.Field != 64 {
+		t.Fatalf("mac in field 64 with secondary bitmap: %v", err)
+	}
+	/*没有MAC域时解析成功,由RequireMAC拒绝*/
+	iso.UnsetField(64)
+	if data, err = iso.Iso2StrEx(); err != nil {
+		t.Fatal(err)
+	}
+	if err = rx.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	h := RequireMAC()(func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		t.Error("handler called without mac")
+		return nil, nil
+	})
+	if rsp, err := h(context.Background(), rx); err == nil || string(rsp.GetField(39)) != "A0" {
+		t.Errorf("without mac: %v", err)
+	}
+}
+
+/*MAC位置错误的请求按无法解析的报文丢弃,不应答A0*/
+func TestMACPositionServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	key := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	sproto := newTestMsg("0800", "000000")
+	sproto.Reset()
+	sproto.SetMAC(MAC_X919, key)
+	srv := NewServer(sproto, FRAME_BIN2)
+	srv.Handle("0200", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		t.Error("handler called")
+		return nil, nil
+	})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := newTestMsg("0200", "000001")
+	req.SetField(64, make([]byte, 8))
+	req.SetField(70, []byte("301"))
+	if err = NewEncoder(conn, FRAME_BIN2).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err = NewDecoder(conn, sproto, FRAME_BIN2).ReadFrame(); err == nil {
+		t.Fatal("server replied to a message with mac in field 64")
+	}
+}
+
+func TestMACServerClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	key := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	sproto := newTestMsg("0800", "000000")
+	sproto.Reset()
+	sproto.SetMAC(MAC_X919, key)
+	srv := NewServer(sproto, FRAME_BIN2)
+	srv.Use(RequireMAC())
+	srv.Handle("0200", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		rsp, err := req.NewEchoResponse()
+		if err == nil {
+			err = rsp.SetField(39, []byte("00"))
+		}
+		return rsp, err
+	})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	send := func(key []byte) (*IsoEx, error) {
+		proto := newTestMsg("0800", "000000")
+		proto.Reset()
+		proto.SetMAC(MAC_X919, key)
+		c := NewClient(ln.Addr().String(), proto, FRAME_BIN2)
+		defer c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		req := newTestMsg("0200", "000001")
+		req.SetMAC(MAC_X919, key)
+		return c.Send(ctx, req)
+	}
+	rsp, err := send(key)
+	if err != nil || string(rsp.GetField(39)) != "00" {
+		t.Fatalf("same key: %v", err)
+	}
+	/*密钥不同:主机应答A0,应答的MAC在客户端也校验失败*/
+	rsp, err = send(mustHex("FEDCBA98765432100123456789ABCDEF"))
+	if !errors.Is(err, ErrBadMAC) || string(rsp.GetField(39)) != "A0" {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
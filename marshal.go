@@ -0,0 +1,401 @@
+package iso8583
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fieldTag struct {
+	no        int
+	amount    bool
+	omitempty bool
+	layout    string
+	length    int
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func parseFieldTag(tag string) (fieldTag, error) {
+	var ft fieldTag
+	parts := strings.Split(tag, ",")
+	if parts[0] == "mti" {
+		parts[0] = "0"
+	}
+	no, err := strconv.Atoi(parts[0])
+	if err != nil || no < 0 || no > 192 {
+		return ft, fmt.Errorf("bad tag %q", tag)
+	}
+	ft.no = no
+	for _, opt := range parts[1:] {
+		switch {
+		case opt == "amount":
+			ft.amount = true
+		case opt == "omitempty":
+			ft.omitempty = true
+		case strings.HasPrefix(opt, "layout="):
+			ft.layout = opt[7:]
+		case strings.HasPrefix(opt, "len="):
+			if ft.length, err = strconv.Atoi(opt[4:]); err != nil || ft.length <= 0 {
+				return ft, fmt.Errorf("bad tag %q", tag)
+			}
+		default:
+			return ft, fmt.Errorf("unknown tag option %q", opt)
+		}
+	}
+	return ft, nil
+}
+
+type taggedField struct {
+	index int
+	tag   fieldTag
+}
+
+/*结构体中带iso8583标签的导出字段,按N排序*/
+func taggedFields(t reflect.Type) ([]taggedField, error) {
+	var fields []taggedField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("iso8583")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		ft, err := parseFieldTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if f.tag.no == ft.no {
+				return nil, fmt.Errorf("%d tagged twice in %s", ft.no, t)
+			}
+		}
+		fields = append(fields, taggedField{i, ft})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag.no < fields[j].tag.no })
+	return fields, nil
+}
+
+/*
+Marshal 按结构体标签打包报文,Unmarshal 解包到结构体.结构体标签 `iso8583:"N[,选项]"`,N为域号,0或mti表示报文类型.选项:
+
+	amount     金额:浮点数按分(两位小数)转换,借贷记域按正负加'C'/'D'
+	layout=L   time.Time的格式,标准日期时间域可省略
+	omitempty  零值不打包
+	len=L      子域定长,子域结构体中使用
+
+域值可以是string、整数、[]byte、time.Time、由子域组成的结构体或它们的指针,
+指针为nil时不打包.子域按N的顺序依次拼接,定长的字符串右补空格、整数左补0,
+未指定len的子域取剩余的全部数据.
+*/
+func Marshal(v interface{}, spec *Spec) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("iso8583: Marshal(%T): need struct", v)
+	}
+	fields, err := taggedFields(rv.Type())
+	if err != nil {
+		return nil, fmt.Errorf("iso8583: %v", err)
+	}
+	iso, err := NewIsoExSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if f.tag.omitempty && fv.IsZero() {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if f.tag.no == 0 {
+			if fv.Kind() != reflect.String {
+				return nil, fmt.Errorf("iso8583: mti must be string, not %s", fv.Type())
+			}
+			if err = iso.SetMTI(fv.String()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if f.tag.no > len(spec.Fields) {
+			return nil, fmt.Errorf("iso8583: field %d: %v", f.tag.no, ErrNoFieldDef)
+		}
+		value, err := encodeValue(fv, f.tag, &spec.Fields[f.tag.no-1])
+		if err != nil {
+			return nil, fmt.Errorf("iso8583: field %d: %v", f.tag.no, err)
+		}
+		if err = iso.SetField(f.tag.no, value); err != nil {
+			return nil, fmt.Errorf("iso8583: field %d: %v", f.tag.no, err)
+		}
+	}
+	return iso.Iso2StrEx()
+}
+
+func Unmarshal(data []byte, v interface{}, spec *Spec) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("iso8583: Unmarshal(%T): need non-nil struct pointer", v)
+	}
+	rv = rv.Elem()
+	fields, err := taggedFields(rv.Type())
+	if err != nil {
+		return fmt.Errorf("iso8583: %v", err)
+	}
+	iso, err := NewIsoExSpec(spec)
+	if err != nil {
+		return err
+	}
+	if err = iso.Str2IsoEx(data); err != nil {
+		return err
+	}
+
+	/*报文中没有的域保持原值*/
+	for _, f := range fields {
+		var value []byte
+		var def *IsoFieldDef
+		if f.tag.no == 0 {
+			value = []byte(iso.MTI())
+		} else if iso.HasField(f.tag.no) {
+			def = &iso.fdef[f.tag.no-1]
+			value = iso.GetField(f.tag.no)
+			if def.LenType == ISO_LEN_FIX && def.padByte() == ' ' && !def.RightJust {
+				value = bytes.TrimRight(value, " ")
+			}
+		} else {
+			continue
+		}
+		if err = decodeValue(rv.Field(f.index), f.tag, def, value); err != nil {
+			return fmt.Errorf("iso8583: field %d: %v", f.tag.no, err)
+		}
+	}
+	return nil
+}
+
+/*def为nil时是子域*/
+func encodeValue(fv reflect.Value, tag fieldTag, def *IsoFieldDef) ([]byte, error) {
+	cd := def != nil && def.DataEnc == ISODC_D
+	switch fv.Kind() {
+	case reflect.String:
+		return []byte(fv.String()), nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			return fv.Bytes(), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeAmount(fv.Int(), cd)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return encodeAmount(int64(fv.Uint()), cd)
+	case reflect.Float32, reflect.Float64:
+		if tag.amount {
+			return encodeAmount(int64(math.Round(fv.Float()*100)), cd)
+		}
+		return nil, errors.New("float value needs amount option")
+	case reflect.Struct:
+		if fv.Type() == timeType {
+			layout, err := timeLayout(tag)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(fv.Interface().(time.Time).Format(layout)), nil
+		}
+		return encodeSubfields(fv)
+	}
+	return nil, fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+func encodeAmount(n int64, cd bool) ([]byte, error) {
+	switch {
+	case cd && n < 0:
+		return []byte("D" + strconv.FormatUint(uint64(-n), 10)), nil
+	case cd:
+		return []byte("C" + strconv.FormatInt(n, 10)), nil
+	case n < 0:
+		return nil, fmt.Errorf("negative value %d", n)
+	}
+	return []byte(strconv.FormatInt(n, 10)), nil
+}
+
+func timeLayout(tag fieldTag) (string, error) {
+	if tag.layout != "" {
+		return tag.layout, nil
+	}
+	if layout, ok := isoTimeLayouts[tag.no]; ok && tag.length == 0 {
+		return layout, nil
+	}
+	return "", errors.New("time value needs layout option")
+}
+
+func encodeSubfields(sv reflect.Value) ([]byte, error) {
+	fields, err := taggedFields(sv.Type())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for i, f := range fields {
+		fv := sv.Field(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv = reflect.Zero(fv.Type().Elem())
+			} else {
+				fv = fv.Elem()
+			}
+		}
+		if f.tag.length == 0 && i != len(fields)-1 {
+			return nil, fmt.Errorf("subfield %d: len required", f.tag.no)
+		}
+		value, err := encodeValue(fv, f.tag, nil)
+		if err != nil {
+			return nil, fmt.Errorf("subfield %d: %v", f.tag.no, err)
+		}
+		if f.tag.length > 0 {
+			if len(value) > f.tag.length {
+				return nil, fmt.Errorf("subfield %d: %d bytes exceeds len %d", f.tag.no, len(value), f.tag.length)
+			}
+			pad := bytes.Repeat([]byte{' '}, f.tag.length-len(value))
+			switch fv.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+				value = append(bytes.Repeat([]byte{'0'}, len(pad)), value...)
+			default:
+				value = append(value, pad...)
+			}
+		}
+		buf.Write(value)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValue(fv reflect.Value, tag fieldTag, def *IsoFieldDef, value []byte) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+	cd := def != nil && def.DataEnc == ISODC_D
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(string(value))
+		return nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes(append([]byte(nil), value...))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := decodeAmount(value, cd)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, fv.Type())
+		}
+		fv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := decodeAmount(value, cd)
+		if err != nil {
+			return err
+		}
+		if n < 0 || fv.OverflowUint(uint64(n)) {
+			return fmt.Errorf("value %d overflows %s", n, fv.Type())
+		}
+		fv.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if !tag.amount {
+			return errors.New("float value needs amount option")
+		}
+		n, err := decodeAmount(value, cd)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(float64(n) / 100)
+		return nil
+	case reflect.Struct:
+		if fv.Type() == timeType {
+			layout, err := timeLayout(tag)
+			if err != nil {
+				return err
+			}
+			t, err := time.Parse(layout, string(value))
+			if err != nil {
+				return err
+			}
+			fv.Set(reflect.ValueOf(t))
+			return nil
+		}
+		return decodeSubfields(fv, value)
+	}
+	return fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+func decodeAmount(value []byte, cd bool) (int64, error) {
+	s := strings.TrimSpace(string(value))
+	neg := false
+	if cd && len(s) > 0 {
+		switch s[0] {
+		case 'C':
+		case 'D':
+			neg = true
+		default:
+			return 0, fmt.Errorf("bad credit/debit sign %q", s[0])
+		}
+		s = s[1:]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func decodeSubfields(sv reflect.Value, value []byte) error {
+	fields, err := taggedFields(sv.Type())
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		if len(value) == 0 {
+			break
+		}
+		if f.tag.length == 0 && i != len(fields)-1 {
+			return fmt.Errorf("subfield %d: len required", f.tag.no)
+		}
+		sub := value
+		if f.tag.length > 0 && f.tag.length < len(value) {
+			sub = value[:f.tag.length]
+		}
+		value = value[len(sub):]
+
+		fv := sv.Field(f.index)
+		kind := fv.Kind()
+		if kind == reflect.Ptr {
+			kind = fv.Type().Elem().Kind()
+		}
+		if kind == reflect.String {
+			sub = bytes.TrimRight(sub, " ")
+		}
+		if err = decodeValue(fv, f.tag, nil, sub); err != nil {
+			return fmt.Errorf("subfield %d: %v", f.tag.no, err)
+		}
+	}
+	return nil
+}
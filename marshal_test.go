@@ -0,0 +1,109 @@
+package iso8583
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type testAddData struct {
+	Kind   string `iso8583:"1,len=2"`
+	Count  int    `iso8583:"2,len=3"`
+	Remark string `iso8583:"3"`
+}
+
+type testPayment struct {
+	MTI      string       `iso8583:"mti"`
+	PAN      string       `iso8583:"2"`
+	ProcCode string       `iso8583:"3"`
+	Amount   float64      `iso8583:"4,amount"`
+	DateTime time.Time    `iso8583:"7"`
+	STAN     uint32       `iso8583:"11"`
+	Fee      int64        `iso8583:"28,amount"`
+	Terminal string       `iso8583:"41"`
+	AddData  *testAddData `iso8583:"48"`
+	Currency string       `iso8583:"49,omitempty"`
+	PIN      []byte       `iso8583:"52,omitempty"`
+	Settle   time.Time    `iso8583:"15,layout=0102,omitempty"`
+	ignored  string
+}
+
+func TestMarshal(t *testing.T) {
+	spec := SpecFromIsoExDef("YL", BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	/*第48域附加数据按ASCII打包*/
+	spec.Fields[47].DataEnc = ISODASC
+	v := testPayment{
+		MTI:      "0200",
+		PAN:      "6222020000001234",
+		ProcCode: "000000",
+		Amount:   12.34,
+		DateTime: time.Date(0, 10, 18, 9, 30, 0, 0, time.UTC),
+		STAN:     42,
+		Fee:      -150,
+		Terminal: "T01",
+		AddData:  &testAddData{"PA", 7, "hello"},
+		PIN:      []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	data, err := Marshal(&v, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iso, _ := NewIsoExSpec(spec)
+	if err = iso.Str2IsoEx(data); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[int]string{
+		4:  "000000001234",
+		7:  "1018093000",
+		11: "000042",
+		28: "D00000150",
+		41: "T01     ",
+		48: "PA007hello",
+	}
+	for n, s := range expect {
+		if string(iso.GetField(n)) != s {
+			t.Fatalf("field %d [%s] expect [%s]", n, iso.GetField(n), s)
+		}
+	}
+	if iso.HasField(15) || iso.HasField(49) || !iso.HasField(52) {
+		t.Fatal("omitempty")
+	}
+
+	var v2 testPayment
+	v2.Currency = "156"
+	if err = Unmarshal(data, &v2, spec); err != nil {
+		t.Fatal(err)
+	}
+	if v2.MTI != v.MTI || v2.PAN != v.PAN || v2.Amount != v.Amount || !v2.DateTime.Equal(v.DateTime) ||
+		v2.STAN != v.STAN || v2.Fee != v.Fee || v2.Terminal != v.Terminal || *v2.AddData != *v.AddData ||
+		v2.Currency != "156" || !bytes.Equal(v2.PIN, v.PIN) {
+		t.Fatalf("Unmarshal %+v", v2)
+	}
+
+	bad := []interface{}{
+		struct {
+			A string `iso8583:"2,bogus"`
+		}{},
+		struct {
+			A float64 `iso8583:"4"`
+		}{},
+		struct {
+			A time.Time `iso8583:"41"`
+		}{},
+		struct {
+			A int `iso8583:"4"`
+		}{-1},
+		struct {
+			A map[string]string `iso8583:"48"`
+		}{},
+	}
+	for _, b := range bad {
+		if _, err = Marshal(b, spec); err == nil {
+			t.Fatalf("Marshal accept %#v", b)
+		}
+	}
+	if err = Unmarshal(data, v2, spec); err == nil {
+		t.Fatal("Unmarshal accept non-pointer")
+	}
+}
@@ -0,0 +1,276 @@
+package iso8583
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*Mux 选择链路的策略*/
+const MUX_ROUND_ROBIN = 0
+const MUX_LEAST_INFLIGHT = 1
+
+var ErrNoLink = errors.New("no link available")
+
+/*Mux 使用的链路,Client与Session都实现;InFlight包括不经过Mux发送的请求*/
+type Link interface {
+	Send(ctx context.Context, req *IsoEx) (*IsoEx, error)
+	InFlight() int
+	Up() bool
+}
+
+/*链路状态*/
+type LinkStatus struct {
+	Name      string
+	Up        bool
+	Draining  bool
+	InFlight  int
+	Sent      uint64 /*成功收到应答的请求数*/
+	Failed    uint64
+	LastError string
+}
+
+type muxLink struct {
+	name     string
+	link     Link
+	inflight atomic.Int32 /*经Mux发送未完成的请求数,Drain等待其为0*/
+	sent     atomic.Uint64
+	failed   atomic.Uint64
+
+	/*以下由Mux.mu保护*/
+	draining  bool
+	downUntil time.Time
+	lastErr   error
+}
+
+/*
+Mux 把请求分配到同一主机的多条链路上.应答在发出请求的链路上匹配,
+Mux分配的流水号在各链路间唯一;请求未发出时(连接失败、未签到)换一条链路重发,
+已发出的请求不重发,以免重复交易.
+*/
+type Mux struct {
+	policy   int
+	stan     *STANCounter
+	matchKey MatchKeyFunc
+	retry    time.Duration
+
+	mu      sync.Mutex
+	links   []*muxLink
+	next    int
+	pending map[string]struct{}
+}
+
+func NewMux(policy int) *Mux {
+	return &Mux{
+		policy:   policy,
+		stan:     &STANCounter{},
+		matchKey: DefaultMatchKey,
+		retry:    5 * time.Second,
+		pending:  make(map[string]struct{}),
+	}
+}
+
+/*流水号生成器,应与各链路的Session共享(SessionConfig.STAN)*/
+func (m *Mux) SetSTAN(c *STANCounter) {
+	m.stan = c
+}
+
+func (m *Mux) STAN() *STANCounter {
+	return m.stan
+}
+
+/*与各链路的Client使用相同的匹配键*/
+func (m *Mux) SetMatchKey(f MatchKeyFunc) {
+	m.matchKey = f
+}
+
+/*链路失败后暂停使用的时间*/
+func (m *Mux) SetRetryInterval(d time.Duration) {
+	m.retry = d
+}
+
+func (m *Mux) Add(name string, l Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ml := range m.links {
+		if ml.name == name {
+			return fmt.Errorf("link %s already exists", name)
+		}
+	}
+	m.links = append(m.links, &muxLink{name: name, link: l})
+	return nil
+}
+
+func (m *Mux) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, ml := range m.links {
+		if ml.name == name {
+			m.links = append(m.links[:i:i], m.links[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("link %s not found", name)
+}
+
+func (m *Mux) find(name string) *muxLink {
+	for _, ml := range m.links {
+		if ml.name == name {
+			return ml
+		}
+	}
+	return nil
+}
+
+/*按策略选择一条可用的链路,跳过tried中的链路*/
+func (m *Mux) pick(tried map[*muxLink]bool) *muxLink {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var best *muxLink
+	best_i, best_load := 0, 0
+	n := len(m.links)
+	for i := 0; i < n; i++ {
+		ml := m.links[(m.next+i)%n]
+		if tried[ml] || ml.draining || now.Before(ml.downUntil) || !ml.link.Up() {
+			continue
+		}
+		load := ml.link.InFlight()
+		if best == nil || m.policy == MUX_LEAST_INFLIGHT && load < best_load {
+			best, best_i, best_load = ml, (m.next+i)%n, load
+		}
+		if m.policy != MUX_LEAST_INFLIGHT {
+			break
+		}
+	}
+	if best != nil {
+		m.next = (best_i + 1) % n
+	}
+	return best
+}
+
+func (m *Mux) fail(ml *muxLink, err error, down bool) {
+	ml.failed.Add(1)
+	m.mu.Lock()
+	ml.lastErr = err
+	if down {
+		ml.downUntil = time.Now().Add(m.retry)
+	}
+	m.mu.Unlock()
+}
+
+/*
+Send 选择链路发送请求.未设置第11域时由Mux分配流水号;
+同一匹配键的请求在所有链路上只能有一个未完成.
+*/
+func (m *Mux) Send(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+	if !req.HasField(11) {
+		if err := req.SetField(11, []byte(m.stan.Next())); err != nil {
+			return nil, err
+		}
+	}
+	key := m.matchKey(req)
+	m.mu.Lock()
+	if _, dup := m.pending[key]; dup {
+		m.mu.Unlock()
+		return nil, ErrDuplicateRequest
+	}
+	m.pending[key] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, key)
+		m.mu.Unlock()
+	}()
+
+	tried := make(map[*muxLink]bool)
+	lastErr := ErrNoLink
+	for {
+		ml := m.pick(tried)
+		if ml == nil {
+			return nil, lastErr
+		}
+		tried[ml] = true
+		ml.inflight.Add(1)
+		rsp, err := ml.link.Send(ctx, req)
+		ml.inflight.Add(-1)
+		if err == nil {
+			ml.sent.Add(1)
+			return rsp, nil
+		}
+		if errors.Is(err, ErrNotSent) || errors.Is(err, ErrNotSignedOn) {
+			/*调用者取消或超时导致没有发出时,不算链路故障*/
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			m.fail(ml, err, true)
+			lastErr = err
+			continue
+		}
+		/*超时不影响链路状态;连接断开的链路暂停使用*/
+		timeout := errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+		m.fail(ml, err, !timeout && !errors.Is(err, ErrDuplicateRequest))
+		return nil, err
+	}
+}
+
+func (m *Mux) Health() []LinkStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	status := make([]LinkStatus, 0, len(m.links))
+	for _, ml := range m.links {
+		st := LinkStatus{
+			Name:     ml.name,
+			Up:       ml.link.Up() && !now.Before(ml.downUntil),
+			Draining: ml.draining,
+			InFlight: ml.link.InFlight(),
+			Sent:     ml.sent.Load(),
+			Failed:   ml.failed.Load(),
+		}
+		if ml.lastErr != nil {
+			st.LastError = ml.lastErr.Error()
+		}
+		status = append(status, st)
+	}
+	return status
+}
+
+/*Drain 停止向链路分配新请求,等待其未完成的请求结束或ctx结束*/
+func (m *Mux) Drain(ctx context.Context, name string) error {
+	m.mu.Lock()
+	ml := m.find(name)
+	if ml != nil {
+		ml.draining = true
+	}
+	m.mu.Unlock()
+	if ml == nil {
+		return fmt.Errorf("link %s not found", name)
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for ml.inflight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+/*恢复使用链路,并清除失败后的暂停*/
+func (m *Mux) Resume(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ml := m.find(name)
+	if ml == nil {
+		return fmt.Errorf("link %s not found", name)
+	}
+	ml.draining = false
+	ml.downUntil = time.Time{}
+	return nil
+}
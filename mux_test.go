@@ -0,0 +1,198 @@
+package iso8583
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeLink struct {
+	name     string
+	err      error
+	block    chan struct{}
+	calls    atomic.Int32
+	inflight atomic.Int32
+}
+
+func (l *fakeLink) Send(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+	l.calls.Add(1)
+	l.inflight.Add(1)
+	defer l.inflight.Add(-1)
+	if l.block != nil {
+		<-l.block
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	rsp, _ := req.NewEchoResponse(11, 41)
+	rsp.SetField(44, []byte(l.name))
+	return rsp, nil
+}
+
+func (l *fakeLink) InFlight() int { return int(l.inflight.Load()) }
+func (l *fakeLink) Up() bool      { return true }
+
+func TestMuxRoundRobin(t *testing.T) {
+	m := NewMux(MUX_ROUND_ROBIN)
+	a, b := &fakeLink{name: "A"}, &fakeLink{name: "B"}
+	m.Add("A", a)
+	m.Add("B", b)
+	if err := m.Add("A", a); err == nil {
+		t.Error("duplicate link name accepted")
+	}
+	ctx := context.Background()
+	got := ""
+	for i := 0; i < 4; i++ {
+		req := newTestMsg("0200", "")
+		req.UnsetField(11)
+		rsp, err := m.Send(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(rsp.GetField(44))
+		if stan := string(rsp.GetField(11)); stan != []string{"000001", "000002", "000003", "000004"}[i] {
+			t.Errorf("stan %s", stan)
+		}
+	}
+	if got != "ABAB" {
+		t.Errorf("round robin %s", got)
+	}
+
+	/*A未发出请求时转到B;已发出的请求失败后不重发*/
+	a.err = notSentError{errors.New("dial failed")}
+	rsp, err := m.Send(ctx, newTestMsg("0200", "000010"))
+	if err != nil || string(rsp.GetField(44)) != "B" {
+		t.Fatalf("failover: %v", err)
+	}
+	if st := m.Health(); st[0].Up || st[0].Failed != 1 || st[0].LastError != "dial failed" || !st[1].Up || st[1].Sent != 3 {
+		t.Errorf("health %+v", st)
+	}
+	b.err = ErrDisconnected
+	if _, err = m.Send(ctx, newTestMsg("0200", "000011")); err != ErrDisconnected {
+		t.Errorf("sent request error: %v", err)
+	}
+	if a.calls.Load() != 3 || b.calls.Load() != 4 {
+		t.Errorf("calls A %d B %d", a.calls.Load(), b.calls.Load())
+	}
+	if _, err = m.Send(ctx, newTestMsg("0200", "000012")); err != ErrNoLink {
+		t.Errorf("all links down: %v", err)
+	}
+	a.err, b.err = nil, nil
+	m.Resume("A")
+	if rsp, err = m.Send(ctx, newTestMsg("0200", "000013")); err != nil || string(rsp.GetField(44)) != "A" {
+		t.Errorf("resume: %v", err)
+	}
+}
+
+func TestMuxDrain(t *testing.T) {
+	m := NewMux(MUX_LEAST_INFLIGHT)
+	a, b := &fakeLink{name: "A", block: make(chan struct{})}, &fakeLink{name: "B"}
+	m.Add("A", a)
+	m.Add("B", b)
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, err := m.Send(ctx, newTestMsg("0200", "000001"))
+		done <- err
+	}()
+	for a.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := m.Send(ctx, newTestMsg("0200", "000001")); err != ErrDuplicateRequest {
+		t.Errorf("duplicate across links: %v", err)
+	}
+	/*A有未完成的请求,选择B*/
+	rsp, err := m.Send(ctx, newTestMsg("0200", "000002"))
+	if err != nil || string(rsp.GetField(44)) != "B" {
+		t.Fatalf("least in flight: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err = m.Drain(short, "A"); err != context.DeadlineExceeded {
+		t.Errorf("drain with request in flight: %v", err)
+	}
+	if st := m.Health(); !st[0].Draining || st[0].InFlight != 1 {
+		t.Errorf("health %+v", st)
+	}
+	close(a.block)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Drain(ctx, "A"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if rsp, err = m.Send(ctx, newTestMsg("0200", "000003")); err != nil || string(rsp.GetField(44)) != "B" {
+			t.Fatalf("drained link used: %v", err)
+		}
+	}
+	if err = m.Drain(ctx, "C"); err == nil {
+		t.Error("unknown link drained")
+	}
+
+	/*链路上不经过Mux的请求也计入*/
+	m.Resume("A")
+	b.inflight.Add(5)
+	if rsp, err = m.Send(ctx, newTestMsg("0200", "000004")); err != nil || string(rsp.GetField(44)) != "A" {
+		t.Fatalf("busy link used: %v", err)
+	}
+	if st := m.Health(); st[1].InFlight != 5 {
+		t.Errorf("health %+v", st)
+	}
+}
+
+/*调用者取消导致请求未发出时,链路不暂停*/
+func TestMuxCancelNotSent(t *testing.T) {
+	m := NewMux(MUX_ROUND_ROBIN)
+	a := &fakeLink{name: "A", err: notSentError{context.Canceled}}
+	m.Add("A", a)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Send(ctx, newTestMsg("0200", "000001")); !errors.Is(err, ErrNotSent) {
+		t.Fatalf("cancelled: %v", err)
+	}
+	if st := m.Health(); !st[0].Up || st[0].Failed != 0 {
+		t.Errorf("health %+v", st)
+	}
+}
+
+func TestMuxClientFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go testHost(ln, 1)
+
+	/*取一个没有监听的端口*/
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := dead.Addr().String()
+	dead.Close()
+
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+	c1 := NewClient(addr, proto, FRAME_BIN2)
+	c2 := NewClient(ln.Addr().String(), proto, FRAME_BIN2)
+	defer c2.Close()
+	m := NewMux(MUX_ROUND_ROBIN)
+	m.Add("dead", c1)
+	m.Add("live", c2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rsp, err := m.Send(ctx, newTestMsg("0200", "000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp.GetField(39)) != "00" {
+		t.Errorf("response code %s", rsp.GetField(39))
+	}
+	if st := m.Health(); st[0].Up || st[0].Failed != 1 || st[1].Sent != 1 {
+		t.Errorf("health %+v", st)
+	}
+}
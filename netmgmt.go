@@ -0,0 +1,414 @@
+package iso8583
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*网络管理信息码(第70域)*/
+const NMC_SIGNON = "001"
+const NMC_SIGNOFF = "002"
+const NMC_KEYCHANGE = "101"
+const NMC_ECHO = "301"
+
+/*会话状态*/
+const STATE_DISCONNECTED = 0
+const STATE_CONNECTING = 1
+const STATE_CONNECTED = 2 /*已连接,未签到*/
+const STATE_SIGNEDON = 3
+const STATE_CLOSED = 4
+
+var ErrNotSignedOn = errors.New("not signed on")
+
+var stateNames = []string{"disconnected", "connecting", "connected", "signed on", "closed"}
+
+func StateName(state int) string {
+	if state < 0 || state >= len(stateNames) {
+		return strconv.Itoa(state)
+	}
+	return stateNames[state]
+}
+
+/*
+SessionConfig 网络管理配置.网络管理信息码放在CodeField域,域值为CodePrefix加信息码;
+银联规范放在第60域,如CodeField=60、CodePrefix="00"+批次号.
+零值的项使用默认值.
+*/
+type SessionConfig struct {
+	MTI        string /*网络管理报文类型,默认0800*/
+	CodeField  int    /*默认70*/
+	CodePrefix string
+	SignOn     string /*默认NMC_SIGNON*/
+	SignOff    string /*默认NMC_SIGNOFF,为"-"时关闭前不签退*/
+	Echo       string /*默认NMC_ECHO*/
+	KeyChange  string /*默认NMC_KEYCHANGE*/
+
+	Heartbeat  time.Duration /*回响测试间隔,默认60秒,小于0时不做*/
+	Timeout    time.Duration /*网络管理请求的超时,默认10秒*/
+	BackoffMin time.Duration /*重连间隔,从BackoffMin起每次加倍至BackoffMax,默认1秒、60秒*/
+	BackoffMax time.Duration
+
+	/*系统跟踪号,多条链路共用主机时应共享同一个生成器;默认每个会话单独计数*/
+	STAN *STANCounter
+	/*发送前补充网络管理请求的其他域,如第41、42域*/
+	Prepare func(req *IsoEx)
+	/*签到应答,返回错误时视为签到失败;可在此处理应答中的工作密钥*/
+	OnSignOn func(rsp *IsoEx) error
+	/*主机发来的密钥变更请求,返回错误时应答96*/
+	OnKeyChange func(req *IsoEx) error
+	/*状态变化,err为断开或签到失败的原因*/
+	OnState func(state int, err error)
+	/*主机主动发来的其他报文*/
+	Handler func(iso *IsoEx)
+}
+
+func (cfg *SessionConfig) setDefaults() {
+	if cfg.MTI == "" {
+		cfg.MTI = "0800"
+	}
+	if cfg.CodeField == 0 {
+		cfg.CodeField = 70
+	}
+	if cfg.SignOn == "" {
+		cfg.SignOn = NMC_SIGNON
+	}
+	if cfg.SignOff == "" {
+		cfg.SignOff = NMC_SIGNOFF
+	}
+	if cfg.Echo == "" {
+		cfg.Echo = NMC_ECHO
+	}
+	if cfg.KeyChange == "" {
+		cfg.KeyChange = NMC_KEYCHANGE
+	}
+	if cfg.STAN == nil {
+		cfg.STAN = &STANCounter{}
+	}
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = 60 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.BackoffMin <= 0 {
+		cfg.BackoffMin = time.Second
+	}
+	if cfg.BackoffMax < cfg.BackoffMin {
+		cfg.BackoffMax = 60 * time.Second
+		if cfg.BackoffMax < cfg.BackoffMin {
+			cfg.BackoffMax = cfg.BackoffMin
+		}
+	}
+}
+
+/*
+Session 在Client上维护网络管理的生命周期:连接后先签到,签到后按间隔做回响测试,
+应答主机发来的回响测试与密钥变更;断开或回响失败后按退避间隔重连并重新签到.
+签到前Send返回ErrNotSignedOn.
+*/
+type Session struct {
+	client *Client
+	cfg    SessionConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	lost   chan error
+
+	mu    sync.Mutex
+	state int
+	ready chan struct{} /*签到后关闭*/
+}
+
+/*NewSession 接管client的SetHandler与SetDropHandler*/
+func NewSession(c *Client, cfg SessionConfig) *Session {
+	cfg.setDefaults()
+	s := &Session{
+		client: c,
+		cfg:    cfg,
+		lost:   make(chan error, 1),
+		ready:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	c.SetHandler(s.handle)
+	c.SetDropHandler(func(err error) {
+		select {
+		case s.lost <- err:
+		default:
+		}
+	})
+	return s
+}
+
+func (s *Session) Client() *Client {
+	return s.client
+}
+
+func (s *Session) State() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Session) setState(state int, err error) {
+	s.mu.Lock()
+	if s.state == state && err == nil {
+		s.mu.Unlock()
+		return
+	}
+	if state == STATE_SIGNEDON {
+		close(s.ready)
+	} else if s.state == STATE_SIGNEDON {
+		s.ready = make(chan struct{})
+	}
+	s.state = state
+	s.mu.Unlock()
+	if l := s.client.proto.log(); l != nil {
+		attrs := []any{slog.String("addr", s.client.addr), slog.String("state", StateName(state))}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		l.Info("session state", attrs...)
+	}
+	if s.cfg.OnState != nil {
+		s.cfg.OnState(state, err)
+	}
+}
+
+/*系统跟踪号(第11域)生成器,000001~999999循环,可并发使用*/
+type STANCounter struct {
+	n atomic.Uint32
+}
+
+func (c *STANCounter) Next() string {
+	n := c.n.Add(1)
+	return fmt.Sprintf("%06d", (n-1)%999999+1)
+}
+
+func (s *Session) NextSTAN() string {
+	return s.cfg.STAN.Next()
+}
+
+/*启动会话,在后台连接并签到,直到Stop*/
+func (s *Session) Start() {
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+	go s.run()
+}
+
+/*等待签到完成*/
+func (s *Session) Wait(ctx context.Context) error {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*已签到时可用*/
+func (s *Session) Up() bool {
+	return s.State() == STATE_SIGNEDON
+}
+
+func (s *Session) InFlight() int {
+	return s.client.InFlight()
+}
+
+/*签到后发送交易请求*/
+func (s *Session) Send(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+	if s.State() != STATE_SIGNEDON {
+		return nil, ErrNotSignedOn
+	}
+	return s.client.Send(ctx, req)
+}
+
+/*已签到时先签退,然后关闭连接并结束会话*/
+func (s *Session) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	var err error
+	if s.State() == STATE_SIGNEDON && s.cfg.SignOff != "-" {
+		_, err = s.request(ctx, s.cfg.SignOff)
+	}
+	s.cancel()
+	s.client.Close()
+	if done != nil {
+		<-done
+	}
+	s.setState(STATE_CLOSED, nil)
+	return err
+}
+
+func (s *Session) run() {
+	defer close(s.done)
+	backoff := s.cfg.BackoffMin
+	for {
+		/*丢弃上次连接遗留的断开通知*/
+		select {
+		case <-s.lost:
+		default:
+		}
+		s.setState(STATE_CONNECTING, nil)
+		err := s.client.Connect(s.ctx)
+		if err == nil {
+			s.setState(STATE_CONNECTED, nil)
+			_, err = s.request(s.ctx, s.cfg.SignOn)
+		}
+		if err == nil {
+			backoff = s.cfg.BackoffMin
+			s.setState(STATE_SIGNEDON, nil)
+			err = s.keepalive()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.client.Disconnect()
+		s.setState(STATE_DISCONNECTED, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-s.ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > s.cfg.BackoffMax {
+			backoff = s.cfg.BackoffMax
+		}
+	}
+}
+
+/*签到后做回响测试,直到连接断开、回响失败或会话结束*/
+func (s *Session) keepalive() error {
+	var tick <-chan time.Time
+	if s.cfg.Heartbeat > 0 {
+		ticker := time.NewTicker(s.cfg.Heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case err := <-s.lost:
+			/*上次连接遗留的通知*/
+			if s.client.Connected() {
+				continue
+			}
+			return err
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-tick:
+			if _, err := s.request(s.ctx, s.cfg.Echo); err != nil {
+				return fmt.Errorf("echo test: %w", err)
+			}
+		}
+	}
+}
+
+func (s *Session) newRequest(code string) (*IsoEx, error) {
+	req := s.client.proto.Clone()
+	req.Reset()
+	if err := req.SetMTI(s.cfg.MTI); err != nil {
+		return nil, err
+	}
+	if err := req.SetField(11, []byte(s.NextSTAN())); err != nil {
+		return nil, err
+	}
+	if req.checkFieldNo(7) == nil {
+		req.SetField(7, []byte(time.Now().Format("0102150405")))
+	}
+	if err := req.SetField(s.cfg.CodeField, []byte(s.cfg.CodePrefix+code)); err != nil {
+		return nil, err
+	}
+	if s.cfg.Prepare != nil {
+		s.cfg.Prepare(req)
+	}
+	return req, nil
+}
+
+/*发送网络管理请求,应答码不为00时返回错误*/
+func (s *Session) request(ctx context.Context, code string) (*IsoEx, error) {
+	req, err := s.newRequest(code)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	rsp, err := s.client.Send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if rc := string(rsp.GetField(39)); rc != "00" {
+		return nil, fmt.Errorf("network management %s: response code [%s]", code, rc)
+	}
+	if code == s.cfg.SignOn && s.cfg.OnSignOn != nil {
+		if err = s.cfg.OnSignOn(rsp); err != nil {
+			return nil, err
+		}
+	}
+	return rsp, nil
+}
+
+/*报文中的网络管理信息码*/
+func (s *Session) code(iso *IsoEx) string {
+	v := string(iso.GetField(s.cfg.CodeField))
+	if len(v) < len(s.cfg.CodePrefix)+3 {
+		return ""
+	}
+	return v[len(s.cfg.CodePrefix) : len(s.cfg.CodePrefix)+3]
+}
+
+/*应答主机发来的回响测试与密钥变更,其他报文交给Handler*/
+func (s *Session) handle(iso *IsoEx) {
+	if iso.MTI() != s.cfg.MTI {
+		if s.cfg.Handler != nil {
+			s.cfg.Handler(iso)
+		}
+		return
+	}
+	rc := "00"
+	switch s.code(iso) {
+	case s.cfg.Echo:
+	case s.cfg.KeyChange:
+		if s.cfg.OnKeyChange != nil {
+			if err := s.cfg.OnKeyChange(iso); err != nil {
+				if l := s.client.proto.log(); l != nil {
+					l.Warn("key change", slog.String("addr", s.client.addr), slog.Any("error", err))
+				}
+				rc = "96"
+			}
+		}
+	default:
+		if s.cfg.Handler != nil {
+			s.cfg.Handler(iso)
+		}
+		return
+	}
+	rsp, err := iso.NewEchoResponse(7, 11, 32, 41, 42, s.cfg.CodeField)
+	if err == nil {
+		err = rsp.SetField(39, []byte(rc))
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Timeout)
+		err = s.client.Post(ctx, rsp)
+		cancel()
+	}
+	if err != nil {
+		if l := s.client.proto.log(); l != nil {
+			l.Warn("network management response", slog.String("addr", s.client.addr), slog.Any("error", err))
+		}
+	}
+}
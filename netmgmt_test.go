@@ -0,0 +1,155 @@
+package iso8583
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+/*测试主机:每个连接先应答签到;第一个连接发一个密钥变更,应答一次回响后断开*/
+func netmgmtHost(ln net.Listener, codes chan<- string) {
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+	for conn_no := 1; ; conn_no++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		dec := NewDecoder(conn, proto, FRAME_BIN2)
+		enc := NewEncoder(conn, FRAME_BIN2)
+		for {
+			req, err := dec.Decode()
+			if err != nil {
+				break
+			}
+			code := string(req.GetField(70))
+			if req.MTI() == "0810" {
+				codes <- "rsp" + code + string(req.GetField(39))
+				continue
+			}
+			codes <- code
+			rsp, _ := req.NewEchoResponse(11, 41, 70)
+			rsp.SetField(39, []byte("00"))
+			enc.Encode(rsp)
+			if conn_no == 1 && code == NMC_SIGNON {
+				kc := newTestMsg("0800", "500001")
+				kc.SetField(70, []byte(NMC_KEYCHANGE))
+				enc.Encode(kc)
+			}
+			if conn_no == 1 && code == NMC_ECHO {
+				break
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestSession(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	codes := make(chan string, 16)
+	go netmgmtHost(ln, codes)
+
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+	c := NewClient(ln.Addr().String(), proto, FRAME_BIN2)
+	states := make(chan int, 32)
+	keychange := make(chan string, 1)
+	s := NewSession(c, SessionConfig{
+		Heartbeat:  50 * time.Millisecond,
+		Timeout:    time.Second,
+		BackoffMin: 10 * time.Millisecond,
+		Prepare:    func(req *IsoEx) { req.SetField(41, []byte("T0000001")) },
+		OnKeyChange: func(req *IsoEx) error {
+			keychange <- string(req.GetField(11))
+			return nil
+		},
+		OnState: func(state int, err error) { states <- state },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = s.Send(ctx, newTestMsg("0200", "000100")); err != ErrNotSignedOn {
+		t.Fatalf("send before sign on: %v", err)
+	}
+	s.Start()
+
+	/*第一个连接:签到、密钥变更应答、回响后被主机断开;第二个连接重新签到*/
+	want := []string{NMC_SIGNON, "rsp" + NMC_KEYCHANGE + "00", NMC_ECHO, NMC_SIGNON}
+	for _, w := range want {
+		select {
+		case got := <-codes:
+			if got != w {
+				t.Fatalf("host got %s, want %s", got, w)
+			}
+		case <-ctx.Done():
+			t.Fatalf("waiting for %s", w)
+		}
+	}
+	select {
+	case stan := <-keychange:
+		if stan != "500001" {
+			t.Errorf("key change stan %s", stan)
+		}
+	case <-ctx.Done():
+		t.Fatal("waiting for key change")
+	}
+	if err = s.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	/*签到后的回响数不定,等到签退为止*/
+	for signoff := false; !signoff; {
+		select {
+		case got := <-codes:
+			signoff = got == NMC_SIGNOFF
+		case <-ctx.Done():
+			t.Fatal("waiting for sign off")
+		}
+	}
+	if s.State() != STATE_CLOSED {
+		t.Errorf("state %s", StateName(s.State()))
+	}
+
+	close(states)
+	var seq []int
+	for st := range states {
+		if len(seq) == 0 || seq[len(seq)-1] != st {
+			seq = append(seq, st)
+		}
+	}
+	wantseq := []int{STATE_CONNECTING, STATE_CONNECTED, STATE_SIGNEDON, STATE_DISCONNECTED,
+		STATE_CONNECTING, STATE_CONNECTED, STATE_SIGNEDON, STATE_CLOSED}
+	if len(seq) < len(wantseq) {
+		t.Fatalf("states %v", seq)
+	}
+	for i, st := range wantseq {
+		if seq[i] != st {
+			t.Fatalf("states %v, want %v", seq, wantseq)
+		}
+	}
+}
+
+func TestSessionCUPCode(t *testing.T) {
+	proto := newTestMsg("0800", "000000")
+	s := NewSession(NewClient("", proto, FRAME_BIN2), SessionConfig{CodeField: 60, CodePrefix: "00000001", SignOn: "003"})
+	req, err := s.newRequest(s.cfg.SignOn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(req.GetField(60)); v != "00000001003" {
+		t.Errorf("field 60 %s", v)
+	}
+	if code := s.code(req); code != "003" {
+		t.Errorf("code %s", code)
+	}
+	if stan := string(req.GetField(11)); stan != "000001" {
+		t.Errorf("stan %s", stan)
+	}
+}
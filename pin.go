@@ -0,0 +1,257 @@
+package iso8583
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+/*ISO 9564 PIN块格式*/
+const PIN_FORMAT0 = 0 /*PIN与主账号异或(ANSI X9.8)*/
+const PIN_FORMAT1 = 1 /*PIN加随机填充,不用主账号*/
+const PIN_FORMAT3 = 3 /*同格式0,填充为随机的A-F*/
+const PIN_FORMAT4 = 4 /*16字节,AES加密,只有密文形式*/
+
+var ErrBadPINBlock = errors.New("bad pin block")
+
+func checkPIN(pin string) error {
+	if len(pin) < 4 || len(pin) > 12 {
+		return fmt.Errorf("pin length %d out of range 4..12", len(pin))
+	}
+	for i := 0; i < len(pin); i++ {
+		if pin[i] < '0' || pin[i] > '9' {
+			return errors.New("pin must be digits")
+		}
+	}
+	return nil
+}
+
+func checkPAN(pan string) error {
+	if len(pan) < 2 || len(pan) > 19 {
+		return fmt.Errorf("pan length %d out of range", len(pan))
+	}
+	for i := 0; i < len(pan); i++ {
+		if pan[i] < '0' || pan[i] > '9' {
+			return errors.New("pan must be digits")
+		}
+	}
+	return nil
+}
+
+func randomNibbles(n int, min byte) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	for i := range buf {
+		buf[i] = "0123456789ABCDEF"[min+buf[i]%(16-min)]
+	}
+	return buf, nil
+}
+
+/*格式0/3的主账号块:0000加去掉校验位后最右12位*/
+func panBlock(pan string) ([]byte, error) {
+	if err := checkPAN(pan); err != nil {
+		return nil, err
+	}
+	digits := fmt.Sprintf("%012s", pan[:len(pan)-1])
+	return Asc2Bcd([]byte("0000"+digits[len(digits)-12:]), 16, 0), nil
+}
+
+/*NewPINBlock 生成格式0、1、3的明文PIN块(8字节);格式1不需要主账号*/
+func NewPINBlock(format int, pin, pan string) ([]byte, error) {
+	if err := checkPIN(pin); err != nil {
+		return nil, err
+	}
+	var fill []byte
+	var err error
+	switch format {
+	case PIN_FORMAT0:
+		fill = []byte("FFFFFFFFFFFFFF")
+	case PIN_FORMAT1:
+		fill, err = randomNibbles(14, 0)
+	case PIN_FORMAT3:
+		fill, err = randomNibbles(14, 10)
+	case PIN_FORMAT4:
+		return nil, errors.New("pin block format 4 exists only encrypted")
+	default:
+		return nil, fmt.Errorf("unsupported pin block format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	field := fmt.Sprintf("%d%X%s%s", format, len(pin), pin, fill)
+	block := Asc2Bcd([]byte(field[:16]), 16, 0)
+	if format != PIN_FORMAT1 {
+		pb, err := panBlock(pan)
+		if err != nil {
+			return nil, err
+		}
+		xorBlock(block, pb)
+	}
+	return block, nil
+}
+
+/*PIN字段:控制位、长度、PIN,填充须符合格式*/
+func parsePINField(format int, field []byte) (string, error) {
+	if int(field[0]) != '0'+format {
+		return "", ErrBadPINBlock
+	}
+	length := int(field[1] - '0')
+	if field[1] >= 'A' {
+		length = int(field[1]-'A') + 10
+	}
+	if length < 4 || length > 12 {
+		return "", ErrBadPINBlock
+	}
+	pin := string(field[2 : 2+length])
+	if checkPIN(pin) != nil {
+		return "", ErrBadPINBlock
+	}
+	for _, ch := range field[2+length:] {
+		switch format {
+		case PIN_FORMAT0:
+			if ch != 'F' {
+				return "", ErrBadPINBlock
+			}
+		case PIN_FORMAT3:
+			if ch < 'A' || ch > 'F' {
+				return "", ErrBadPINBlock
+			}
+		case PIN_FORMAT4:
+			if ch != 'A' {
+				return "", ErrBadPINBlock
+			}
+		}
+	}
+	return pin, nil
+}
+
+/*ParsePINBlock 从格式0、1、3的明文PIN块取出PIN*/
+func ParsePINBlock(format int, block []byte, pan string) (string, error) {
+	if format != PIN_FORMAT0 && format != PIN_FORMAT1 && format != PIN_FORMAT3 {
+		return "", fmt.Errorf("unsupported pin block format %d", format)
+	}
+	if len(block) != 8 {
+		return "", ErrBadPINBlock
+	}
+	clear := append([]byte(nil), block...)
+	if format != PIN_FORMAT1 {
+		pb, err := panBlock(pan)
+		if err != nil {
+			return "", err
+		}
+		xorBlock(clear, pb)
+	}
+	return parsePINField(format, Bcd2Asc(clear, 16, 0))
+}
+
+/*格式4的主账号块:主账号长度减12、主账号(不足12位左补0),右补0到32位*/
+func panBlock4(pan string) ([]byte, error) {
+	if err := checkPAN(pan); err != nil {
+		return nil, err
+	}
+	m := 0
+	if len(pan) > 12 {
+		m = len(pan) - 12
+	} else {
+		pan = fmt.Sprintf("%012s", pan)
+	}
+	field := fmt.Sprintf("%d%s", m, pan)
+	field += "00000000000000000000000000000000"[len(field):]
+	return Asc2Bcd([]byte(field), 32, 0), nil
+}
+
+func pinCipher(format int, key []byte) (cipher.Block, error) {
+	if format == PIN_FORMAT4 {
+		return aes.NewCipher(key)
+	}
+	return desCipher(key)
+}
+
+/*EncryptPINBlock 生成PIN块并加密:格式0、1、3用DES/3DES密钥,格式4用AES密钥*/
+func EncryptPINBlock(format int, pin, pan string, key []byte) ([]byte, error) {
+	c, err := pinCipher(format, key)
+	if err != nil {
+		return nil, err
+	}
+	if format != PIN_FORMAT4 {
+		block, err := NewPINBlock(format, pin, pan)
+		if err != nil {
+			return nil, err
+		}
+		c.Encrypt(block, block)
+		return block, nil
+	}
+
+	if err = checkPIN(pin); err != nil {
+		return nil, err
+	}
+	pb, err := panBlock4(pan)
+	if err != nil {
+		return nil, err
+	}
+	fill, err := randomNibbles(16, 0)
+	if err != nil {
+		return nil, err
+	}
+	field := fmt.Sprintf("4%X%s", len(pin), pin)
+	field += "AAAAAAAAAAAAAAAA"[len(field):] + string(fill)
+	block := Asc2Bcd([]byte(field), 32, 0)
+	c.Encrypt(block, block)
+	xorBlock(block, pb)
+	c.Encrypt(block, block)
+	return block, nil
+}
+
+/*DecryptPINBlock 解密PIN块并取出PIN*/
+func DecryptPINBlock(format int, block []byte, pan string, key []byte) (string, error) {
+	c, err := pinCipher(format, key)
+	if err != nil {
+		return "", err
+	}
+	if len(block) != c.BlockSize() {
+		return "", ErrBadPINBlock
+	}
+	clear := make([]byte, len(block))
+	c.Decrypt(clear, block)
+	if format != PIN_FORMAT4 {
+		return ParsePINBlock(format, clear, pan)
+	}
+	pb, err := panBlock4(pan)
+	if err != nil {
+		return "", err
+	}
+	xorBlock(clear, pb)
+	c.Decrypt(clear, clear)
+	return parsePINField(format, Bcd2Asc(clear[:8], 16, 0))
+}
+
+/*TranslatePINBlock 把PIN块从一个密钥和格式转换到另一个密钥和格式,PIN不离开本函数*/
+func TranslatePINBlock(block []byte, pan string, from_format int, from_key []byte, to_format int, to_key []byte) ([]byte, error) {
+	pin, err := DecryptPINBlock(from_format, block, pan, from_key)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptPINBlock(to_format, pin, pan, to_key)
+}
+
+/*SetPIN 以第2域为主账号生成加密的PIN块并设置第52域*/
+func (iso *IsoEx) SetPIN(format int, pin string, key []byte) error {
+	block, err := EncryptPINBlock(format, pin, string(iso.GetField(2)), key)
+	if err != nil {
+		return err
+	}
+	return iso.SetField(52, block)
+}
+
+/*PIN 解密第52域的PIN块*/
+func (iso *IsoEx) PIN(format int, key []byte) (string, error) {
+	block := iso.GetField(52)
+	if block == nil {
+		return "", errors.New("field 52 not set")
+	}
+	return DecryptPINBlock(format, block, string(iso.GetField(2)), key)
+}
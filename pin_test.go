@@ -0,0 +1,92 @@
+package iso8583
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPINBlockFormat0(t *testing.T) {
+	block, err := NewPINBlock(PIN_FORMAT0, "1234", "43219876543210987")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(block); got != "0412ac89abcdef67" {
+		t.Errorf("format 0 block %s", got)
+	}
+	pin, err := ParsePINBlock(PIN_FORMAT0, block, "43219876543210987")
+	if err != nil || pin != "1234" {
+		t.Errorf("parse: %s %v", pin, err)
+	}
+	/*主账号不对时填充不是F*/
+	if _, err = ParsePINBlock(PIN_FORMAT0, block, "43219876543210995"); err != ErrBadPINBlock {
+		t.Errorf("wrong pan: %v", err)
+	}
+	for _, bad := range []string{"123", "1234567890123", "12a4"} {
+		if _, err = NewPINBlock(PIN_FORMAT0, bad, "43219876543210987"); err == nil {
+			t.Errorf("pin %s accepted", bad)
+		}
+	}
+}
+
+func TestPINBlockFormats(t *testing.T) {
+	const pan = "6222021234567890123"
+	tdes := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	aeskey := mustHex("000102030405060708090A0B0C0D0E0F")
+	for _, format := range []int{PIN_FORMAT0, PIN_FORMAT1, PIN_FORMAT3, PIN_FORMAT4} {
+		key := tdes
+		if format == PIN_FORMAT4 {
+			key = aeskey
+		}
+		for _, pin := range []string{"1234", "123456789012"} {
+			block, err := EncryptPINBlock(format, pin, pan, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecryptPINBlock(format, block, pan, key)
+			if err != nil || got != pin {
+				t.Errorf("format %d pin %s: %s %v", format, pin, got, err)
+			}
+		}
+	}
+	/*格式3、4的填充随机,每次结果不同*/
+	b1, _ := EncryptPINBlock(PIN_FORMAT4, "1234", pan, aeskey)
+	b2, _ := EncryptPINBlock(PIN_FORMAT4, "1234", pan, aeskey)
+	if len(b1) != 16 || string(b1) == string(b2) {
+		t.Errorf("format 4 blocks %x %x", b1, b2)
+	}
+	if _, err := DecryptPINBlock(PIN_FORMAT4, b1, "6222021234567890124", aeskey); err == nil {
+		t.Error("format 4 wrong pan accepted")
+	}
+	if _, err := NewPINBlock(PIN_FORMAT4, "1234", pan); err == nil {
+		t.Error("clear format 4 block")
+	}
+}
+
+func TestTranslatePINBlock(t *testing.T) {
+	const pan = "6222021234567890123"
+	zpk1 := mustHex("0123456789ABCDEFFEDCBA9876543210")
+	zpk2 := mustHex("111111111111111122222222222222223333333333333333")
+	aeskey := mustHex("000102030405060708090A0B0C0D0E0F")
+
+	iso := newTestMsg("0200", "000001")
+	iso.SetField(2, []byte(pan))
+	if err := iso.SetPIN(PIN_FORMAT0, "8888", zpk1); err != nil {
+		t.Fatal(err)
+	}
+	block, err := TranslatePINBlock(iso.GetField(52), pan, PIN_FORMAT0, zpk1, PIN_FORMAT3, zpk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, err = TranslatePINBlock(block, pan, PIN_FORMAT3, zpk2, PIN_FORMAT4, aeskey); err != nil {
+		t.Fatal(err)
+	}
+	if pin, err := DecryptPINBlock(PIN_FORMAT4, block, pan, aeskey); err != nil || pin != "8888" {
+		t.Errorf("translated pin %s %v", pin, err)
+	}
+	if pin, err := iso.PIN(PIN_FORMAT0, zpk1); err != nil || pin != "8888" {
+		t.Errorf("field 52 pin %s %v", pin, err)
+	}
+	if _, err = iso.PIN(PIN_FORMAT0, zpk2); err == nil {
+		t.Error("wrong key accepted")
+	}
+}
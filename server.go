@@ -0,0 +1,359 @@
+package iso8583
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+/*处理请求,返回应答报文;返回nil时不应答*/
+type HandlerFunc func(ctx context.Context, req *IsoEx) (*IsoEx, error)
+
+/*中间件包装处理函数,先注册的在外层*/
+type Middleware func(next HandlerFunc) HandlerFunc
+
+/*应答默认回送的域:主账号、处理码、金额、传输时间、流水号、本地时间日期、受理机构、参考号、终端号、商户号、货币代码*/
+var EchoFields = []int{2, 3, 4, 7, 11, 12, 13, 32, 37, 41, 42, 49}
+
+/*由请求生成应答(见NewResponse),并复制请求中的回送域,fields为空时使用EchoFields*/
+func (iso *IsoEx) NewEchoResponse(fields ...int) (*IsoEx, error) {
+	rsp, err := iso.NewResponse()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		fields = EchoFields
+	}
+	for _, n := range fields {
+		if v := iso.GetField(n); v != nil {
+			if err = rsp.SetField(n, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rsp, nil
+}
+
+type remoteAddrKey struct{}
+
+/*处理函数中取得对端地址*/
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+/*
+Server 接受连接,按proto的域定义与配置解包请求,按报文类型及处理码(第3域)分发.
+每个连接一个读协程,请求在各自的协程中处理,应答按完成顺序写回.
+*/
+type Server struct {
+	proto     *IsoEx
+	framing   int16
+	tlsConfig *tls.Config
+
+	routes     map[string]HandlerFunc
+	fallback   HandlerFunc
+	middleware []Middleware
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	connwg    sync.WaitGroup
+}
+
+func NewServer(proto *IsoEx, framing int16) *Server {
+	s := &Server{
+		proto:     proto,
+		framing:   framing,
+		routes:    make(map[string]HandlerFunc),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+/*以下设置须在Serve前调用*/
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+/*按报文类型注册处理函数*/
+func (s *Server) Handle(mti string, h HandlerFunc) {
+	s.routes[mti] = h
+}
+
+/*按报文类型及处理码前缀注册,最长的前缀优先,如HandleCode("0200", "31", ...)*/
+func (s *Server) HandleCode(mti, code string, h HandlerFunc) {
+	s.routes[mti+"|"+code] = h
+}
+
+/*没有匹配的处理函数时使用*/
+func (s *Server) SetDefault(h HandlerFunc) {
+	s.fallback = h
+}
+
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+func (s *Server) route(req *IsoEx) HandlerFunc {
+	mti := req.MTI()
+	code := string(req.GetField(3))
+	for n := len(code); n > 0; n-- {
+		if h, ok := s.routes[mti+"|"+code[:n]]; ok {
+			return h
+		}
+	}
+	if h, ok := s.routes[mti]; ok {
+		return h
+	}
+	return s.fallback
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+/*Serve 在Shutdown后返回ErrServerClosed*/
+func (s *Server) Serve(ln net.Listener) error {
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.connwg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+/*未设置日志时使用,丢弃所有记录*/
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func (s *Server) logger() *slog.Logger {
+	if l := s.proto.log(); l != nil {
+		return l
+	}
+	return discardLogger
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.connwg.Done()
+	var wmu sync.Mutex
+	var handlers sync.WaitGroup
+	enc := NewEncoder(conn, s.framing)
+	dec := NewDecoder(conn, s.proto, s.framing)
+	ctx := context.WithValue(s.ctx, remoteAddrKey{}, conn.RemoteAddr())
+
+	for {
+		data, err := dec.ReadFrame()
+		if err != nil {
+			break
+		}
+		if len(data) == 0 {
+			continue
+		}
+		req := s.proto.Clone()
+		req.Reset()
+		if err = req.Str2IsoEx(data); err != nil {
+			if !errors.Is(err, ErrBadMAC) {
+				s.logger().Warn("discard unparsable request", slog.String("remote", conn.RemoteAddr().String()), slog.Any("error", err))
+				continue
+			}
+			s.logger().Warn("bad mac", slog.String("remote", conn.RemoteAddr().String()), slog.String("mti", req.MTI()))
+		}
+		bad_mac := err != nil
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			var rsp *IsoEx
+			if bad_mac {
+				if rsp, _ = req.NewEchoResponse(); rsp != nil {
+					rsp.SetField(39, []byte("A0"))
+				}
+			} else {
+				rsp = s.serveRequest(ctx, req)
+			}
+			if rsp == nil {
+				return
+			}
+			data, err := rsp.Iso2StrEx()
+			if err != nil {
+				s.logger().Warn("pack response", slog.String("mti", rsp.MTI()), slog.Any("error", err))
+				return
+			}
+			wmu.Lock()
+			enc.WriteFrame(data)
+			wmu.Unlock()
+		}()
+	}
+	/*等待已收到的请求处理完再关闭连接*/
+	handlers.Wait()
+	conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *Server) serveRequest(ctx context.Context, req *IsoEx) *IsoEx {
+	h := s.route(req)
+	if h == nil {
+		s.logger().Warn("no handler", slog.String("mti", req.MTI()), slog.String("code", string(req.GetField(3))))
+		return nil
+	}
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	rsp, err := h(ctx, req)
+	if err != nil {
+		s.logger().Warn("handler error", slog.String("mti", req.MTI()), slog.Any("error", err))
+	}
+	return rsp
+}
+
+/*
+Shutdown 停止接受连接和读取新请求,等待已收到的请求处理完并写回应答后关闭连接.
+ctx结束时强制关闭所有连接并返回ctx.Err().
+*/
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connwg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+/*立即关闭所有监听与连接*/
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.cancel()
+	return nil
+}
+
+/*捕获处理函数中的panic,作为错误返回*/
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *IsoEx) (rsp *IsoEx, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					rsp, err = nil, fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+/*记录每个请求的报文类型、处理码、流水号、应答码及耗时;l为nil时使用全局日志*/
+func Logging(l *slog.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+			start := time.Now()
+			rsp, err := next(ctx, req)
+			logger := l
+			if logger == nil {
+				if logger = req.log(); logger == nil {
+					return rsp, err
+				}
+			}
+			attrs := []slog.Attr{
+				slog.String("mti", req.MTI()),
+				slog.String("code", string(req.GetField(3))),
+				slog.String("stan", string(req.GetField(11))),
+				slog.Duration("elapsed", time.Since(start)),
+			}
+			if addr := RemoteAddr(ctx); addr != nil {
+				attrs = append(attrs, slog.String("remote", addr.String()))
+			}
+			if rsp != nil {
+				attrs = append(attrs, slog.String("response", string(rsp.GetField(39))))
+			}
+			level := slog.LevelInfo
+			if err != nil {
+				level = slog.LevelWarn
+				attrs = append(attrs, slog.Any("error", err))
+			}
+			logger.LogAttrs(ctx, level, "request", attrs...)
+			return rsp, err
+		}
+	}
+}
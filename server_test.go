@@ -0,0 +1,142 @@
+package iso8583
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	proto := newTestMsg("0800", "000000")
+	proto.Reset()
+
+	var logbuf syncBuffer
+	srv := NewServer(proto, FRAME_BIN2)
+	srv.Use(Logging(slog.New(slog.NewTextHandler(&logbuf, nil))), Recover())
+	release := make(chan struct{})
+	srv.Handle("0800", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		rsp, err := req.NewEchoResponse()
+		if err != nil {
+			return nil, err
+		}
+		rsp.SetField(39, []byte("00"))
+		return rsp, nil
+	})
+	srv.Handle("0200", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		rsp, _ := req.NewEchoResponse()
+		rsp.SetField(39, []byte("12"))
+		return rsp, nil
+	})
+	srv.HandleCode("0200", "31", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		rsp, _ := req.NewEchoResponse(11, 41)
+		rsp.SetField(39, []byte("31"))
+		return rsp, nil
+	})
+	srv.HandleCode("0200", "310000", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		if RemoteAddr(ctx) == nil {
+			return nil, errors.New("no remote addr")
+		}
+		<-release
+		rsp, _ := req.NewEchoResponse()
+		rsp.SetField(39, []byte("00"))
+		return rsp, nil
+	})
+	srv.HandleCode("0200", "99", func(ctx context.Context, req *IsoEx) (*IsoEx, error) {
+		panic("boom")
+	})
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	c := NewClient(ln.Addr().String(), proto, FRAME_BIN2)
+	defer c.Close()
+	timeout := 2 * time.Second
+	send := func(mti, stan, code string) (*IsoEx, error) {
+		req := newTestMsg(mti, stan)
+		if code != "" {
+			req.SetField(3, []byte(code))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return c.Send(ctx, req)
+	}
+
+	for _, tc := range []struct{ mti, stan, code, rc string }{
+		{"0800", "000001", "", "00"},
+		{"0200", "000002", "000000", "12"},
+		{"0200", "000003", "311000", "31"},
+	} {
+		rsp, err := send(tc.mti, tc.stan, tc.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rsp.GetField(39)) != tc.rc || string(rsp.GetField(11)) != tc.stan {
+			t.Fatalf("%s %s: %s", tc.mti, tc.code, rsp)
+		}
+		if tc.rc == "31" && rsp.HasField(3) {
+			t.Fatal("NewEchoResponse copied field 3")
+		}
+		if tc.rc == "12" && string(rsp.GetField(3)) != tc.code {
+			t.Fatal("NewEchoResponse did not copy field 3")
+		}
+	}
+	/*panic不应答*/
+	timeout = 200 * time.Millisecond
+	if _, err = send("0200", "000004", "990000"); err != context.DeadlineExceeded {
+		t.Fatalf("panic handler: %v", err)
+	}
+	if !strings.Contains(logbuf.String(), "panic: boom") {
+		t.Fatalf("log %s", logbuf.String())
+	}
+
+	/*Shutdown等待处理中的请求完成*/
+	timeout = 2 * time.Second
+	result := make(chan error, 1)
+	go func() {
+		rsp, err := send("0200", "000005", "310000")
+		if err == nil && string(rsp.GetField(39)) != "00" {
+			err = errors.New("bad response")
+		}
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	shut := make(chan error, 1)
+	go func() { shut <- srv.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err = <-result; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-shut; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,531 @@
+package iso8583
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+Spec 报文规范:报文类型、位图、长度前缀的编码及各域定义.
+Fields[i]为第i+1域的定义,Length为0的域未定义.
+*/
+type Spec struct {
+	Name     string
+	MsgType  int16
+	BitType  int16
+	LenType  int16
+	CodePage int
+	Fields   []IsoFieldDef
+}
+
+func NewIsoExSpec(spec *Spec) (*IsoEx, error) {
+	iso, err := NewIsoExFields(spec.MsgType, spec.BitType, spec.LenType, spec.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if spec.CodePage != 0 {
+		if err = iso.SetCodePage(spec.CodePage); err != nil {
+			return nil, err
+		}
+	}
+	return iso, nil
+}
+
+/*由原有的IsoExDef表生成规范,域名取ISO 8583:1987标准名称*/
+func SpecFromIsoExDef(name string, msgtype, bittype, lentype int16, isodef []IsoExDef) *Spec {
+	spec := &Spec{Name: name, MsgType: msgtype, BitType: bittype, LenType: lentype}
+	spec.Fields = ConvertIsoExDef(isodef)
+	for i := range spec.Fields {
+		if i < len(isoFieldNames) {
+			spec.Fields[i].Name = isoFieldNames[i].name
+			spec.Fields[i].Desc = isoFieldNames[i].desc
+		}
+	}
+	return spec
+}
+
+/*规范文件格式,JSON与YAML共用*/
+type specFile struct {
+	Name     string      `json:"name,omitempty"`
+	MTI      string      `json:"mti"`
+	Bitmap   string      `json:"bitmap"`
+	Length   string      `json:"length"`
+	CodePage int         `json:"codepage,omitempty"`
+	Fields   []specField `json:"fields"`
+}
+
+type specField struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name,omitempty"`
+	Desc          string  `json:"description,omitempty"`
+	MaxLength     int     `json:"max_length"`
+	LengthType    string  `json:"length_type"`
+	LengthEnc     string  `json:"length_encoding,omitempty"`
+	Encoding      string  `json:"encoding"`
+	Padding       specPad `json:"padding,omitempty"`
+	Justify       string  `json:"justify"`
+	LengthInBytes bool    `json:"length_in_bytes,omitempty"`
+	Type          string  `json:"type,omitempty"`
+}
+
+/*填充字符:单个字符或"0x00"形式的十六进制字节,YAML中未加引号的数字也接受*/
+type specPad string
+
+func (p *specPad) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		*p = specPad(data)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*p = specPad(s)
+	return nil
+}
+
+func (p specPad) byteValue() (byte, error) {
+	switch {
+	case len(p) == 0:
+		return 0, nil
+	case len(p) == 1:
+		return p[0], nil
+	case len(p) == 4 && (p[:2] == "0x" || p[:2] == "0X"):
+		v, err := strconv.ParseUint(string(p[2:]), 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid padding %q", string(p))
+		}
+		return byte(v), nil
+	}
+	return 0, fmt.Errorf("invalid padding %q", string(p))
+}
+
+func padString(pad byte) specPad {
+	if pad < 0x20 || pad > 0x7e {
+		return specPad(fmt.Sprintf("0x%02x", pad))
+	}
+	return specPad(string(pad))
+}
+
+var encodingNames = map[string]int16{
+	"bcd":    BCDTYPE,
+	"ascii":  ASCTYPE,
+	"hex":    HEXTYPE,
+	"ebcdic": EBCDICTYPE,
+	"binary": BINTYPE,
+}
+
+var lengthTypeNames = []string{"fixed", "LLVAR", "LLLVAR", "LLLLVAR"}
+
+var dataEncNames = map[string]int{
+	"ascii":  ISODASC,
+	"bcd":    ISODBCD,
+	"binary": ISODBIN,
+	"cd":     ISODC_D,
+	"ebcdic": ISODEBC,
+}
+
+func encodingName(enc int16) string {
+	for name, v := range encodingNames {
+		if v == enc {
+			return name
+		}
+	}
+	return strconv.Itoa(int(enc))
+}
+
+func dataEncName(enc int) string {
+	for name, v := range dataEncNames {
+		if v == enc {
+			return name
+		}
+	}
+	return strconv.Itoa(enc)
+}
+
+func parseEncoding(what, name string) (int16, error) {
+	enc, ok := encodingNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown %s encoding %q", what, name)
+	}
+	return enc, nil
+}
+
+/*位图为二进制,或ASCII/EBCDIC的十六进制字符*/
+func bitmapName(bittype int16) string {
+	switch bittype {
+	case BCDTYPE:
+		return "binary"
+	case EBCDICTYPE:
+		return "ebcdic"
+	}
+	return "hex"
+}
+
+func (f *specFile) toSpec() (*Spec, error) {
+	spec := &Spec{Name: f.Name, CodePage: f.CodePage}
+	var err error
+	if spec.MsgType, err = parseEncoding("mti", f.MTI); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(f.Bitmap) {
+	case "binary":
+		spec.BitType = BCDTYPE
+	case "hex", "ascii":
+		spec.BitType = ASCTYPE
+	case "ebcdic":
+		spec.BitType = EBCDICTYPE
+	default:
+		return nil, fmt.Errorf("unknown bitmap encoding %q", f.Bitmap)
+	}
+	if spec.LenType, err = parseEncoding("length", f.Length); err != nil {
+		return nil, err
+	}
+
+	for _, sf := range f.Fields {
+		if sf.ID < 2 || sf.ID > 192 {
+			return nil, fmt.Errorf("field id %d out of range 2..192", sf.ID)
+		}
+		fd, err := sf.toFieldDef()
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %v", sf.ID, err)
+		}
+		for len(spec.Fields) < sf.ID {
+			spec.Fields = append(spec.Fields, IsoFieldDef{})
+		}
+		if spec.Fields[sf.ID-1].defined() {
+			return nil, fmt.Errorf("field %d defined twice", sf.ID)
+		}
+		spec.Fields[sf.ID-1] = fd
+	}
+	if len(spec.Fields) > 0 {
+		spec.Fields[0] = IsoExDef{64, ISOLFIX | ISODBCD | ISOF0 | ISOLJUST}.FieldDef()
+	}
+	return spec, nil
+}
+
+func (sf *specField) toFieldDef() (IsoFieldDef, error) {
+	fd := IsoFieldDef{Name: sf.Name, Desc: sf.Desc, Length: sf.MaxLength, LenInBytes: sf.LengthInBytes, Type: sf.Type}
+	if fd.Length <= 0 {
+		return fd, fmt.Errorf("invalid max_length %d", sf.MaxLength)
+	}
+	fd.LenType = -1
+	for i, name := range lengthTypeNames {
+		if strings.EqualFold(sf.LengthType, name) {
+			fd.LenType = i
+		}
+	}
+	if fd.LenType < 0 {
+		return fd, fmt.Errorf("unknown length_type %q", sf.LengthType)
+	}
+	if sf.LengthEnc != "" && sf.LengthEnc != "default" {
+		enc, err := parseEncoding("length", sf.LengthEnc)
+		if err != nil {
+			return fd, err
+		}
+		fd.LenEnc, fd.LenEncSet = enc, true
+	}
+	enc, ok := dataEncNames[strings.ToLower(sf.Encoding)]
+	if !ok {
+		return fd, fmt.Errorf("unknown encoding %q", sf.Encoding)
+	}
+	fd.DataEnc = enc
+	pad, err := sf.Padding.byteValue()
+	if err != nil {
+		return fd, err
+	}
+	fd.Pad, fd.PadSet = pad, sf.Padding != ""
+	switch strings.ToLower(sf.Justify) {
+	case "left", "":
+	case "right":
+		fd.RightJust = true
+	default:
+		return fd, fmt.Errorf("unknown justify %q", sf.Justify)
+	}
+	return fd, fd.check()
+}
+
+func (spec *Spec) toFile() *specFile {
+	f := &specFile{
+		Name:     spec.Name,
+		MTI:      encodingName(spec.MsgType),
+		Bitmap:   bitmapName(spec.BitType),
+		Length:   encodingName(spec.LenType),
+		CodePage: spec.CodePage,
+	}
+	for i := 1; i < len(spec.Fields); i++ {
+		fd := &spec.Fields[i]
+		if !fd.defined() {
+			continue
+		}
+		sf := specField{
+			ID:            i + 1,
+			Name:          fd.Name,
+			Desc:          fd.Desc,
+			MaxLength:     fd.Length,
+			LengthType:    lengthTypeNames[fd.LenType],
+			Encoding:      dataEncName(fd.DataEnc),
+			Justify:       "left",
+			LengthInBytes: fd.LenInBytes,
+			Type:          fd.Type,
+		}
+		if fd.hasLenEnc() {
+			sf.LengthEnc = encodingName(fd.LenEnc)
+		}
+		if fd.hasPad() {
+			sf.Padding = padString(fd.Pad)
+		}
+		if fd.RightJust {
+			sf.Justify = "right"
+		}
+		f.Fields = append(f.Fields, sf)
+	}
+	return f
+}
+
+/*按内容判断格式:以'{'开头为JSON,否则为YAML*/
+func ParseSpec(r io.Reader) (*Spec, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("empty spec")
+	}
+	if trimmed[0] == '{' {
+		return parseSpecJSON(trimmed)
+	}
+	return parseSpecYAML(data)
+}
+
+func parseSpecJSON(data []byte) (*Spec, error) {
+	var f specFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("spec: %v", err)
+	}
+	return f.toSpec()
+}
+
+func parseSpecYAML(data []byte) (*Spec, error) {
+	tree, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("spec: %v", err)
+	}
+	/*YAML先转换为通用结构,再按JSON解码到规范文件格式*/
+	js, err := json.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("spec: %v", err)
+	}
+	return parseSpecJSON(js)
+}
+
+/*按扩展名识别格式:.json/.yaml/.yml*/
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec *Spec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		spec, err = parseSpecJSON(data)
+	case ".yaml", ".yml":
+		spec, err = parseSpecYAML(data)
+	default:
+		spec, err = ParseSpec(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return spec, nil
+}
+
+func WriteSpecJSON(w io.Writer, spec *Spec) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(spec.toFile())
+}
+
+func WriteSpecYAML(w io.Writer, spec *Spec) error {
+	f := spec.toFile()
+	bw := bufio.NewWriter(w)
+	if f.Name != "" {
+		fmt.Fprintf(bw, "name: %s\n", yamlQuote(f.Name))
+	}
+	fmt.Fprintf(bw, "mti: %s\n", f.MTI)
+	fmt.Fprintf(bw, "bitmap: %s\n", f.Bitmap)
+	fmt.Fprintf(bw, "length: %s\n", f.Length)
+	if f.CodePage != 0 {
+		fmt.Fprintf(bw, "codepage: %d\n", f.CodePage)
+	}
+	fmt.Fprintf(bw, "fields:\n")
+	for _, sf := range f.Fields {
+		fmt.Fprintf(bw, "  - id: %d\n", sf.ID)
+		if sf.Name != "" {
+			fmt.Fprintf(bw, "    name: %s\n", yamlQuote(sf.Name))
+		}
+		if sf.Desc != "" {
+			fmt.Fprintf(bw, "    description: %s\n", yamlQuote(sf.Desc))
+		}
+		fmt.Fprintf(bw, "    max_length: %d\n", sf.MaxLength)
+		fmt.Fprintf(bw, "    length_type: %s\n", sf.LengthType)
+		if sf.LengthEnc != "" {
+			fmt.Fprintf(bw, "    length_encoding: %s\n", sf.LengthEnc)
+		}
+		fmt.Fprintf(bw, "    encoding: %s\n", sf.Encoding)
+		if sf.Padding != "" {
+			fmt.Fprintf(bw, "    padding: %s\n", yamlQuote(string(sf.Padding)))
+		}
+		fmt.Fprintf(bw, "    justify: %s\n", sf.Justify)
+		if sf.LengthInBytes {
+			fmt.Fprintf(bw, "    length_in_bytes: true\n")
+		}
+		if sf.Type != "" {
+			fmt.Fprintf(bw, "    type: %s\n", yamlQuote(sf.Type))
+		}
+	}
+	return bw.Flush()
+}
+
+/*ISO 8583:1987标准域名,下标为域号-1*/
+var isoFieldNames = [...]struct{ name, desc string }{
+	{"Bitmap", "Bitmap"},
+	{"PAN", "Primary account number"},
+	{"ProcessingCode", "Processing code"},
+	{"Amount", "Amount, transaction"},
+	{"SettlementAmount", "Amount, settlement"},
+	{"BillingAmount", "Amount, cardholder billing"},
+	{"TransmissionDateTime", "Transmission date and time"},
+	{"BillingFee", "Amount, cardholder billing fee"},
+	{"SettlementConversionRate", "Conversion rate, settlement"},
+	{"BillingConversionRate", "Conversion rate, cardholder billing"},
+	{"STAN", "System trace audit number"},
+	{"LocalTime", "Time, local transaction"},
+	{"LocalDate", "Date, local transaction"},
+	{"ExpirationDate", "Date, expiration"},
+	{"SettlementDate", "Date, settlement"},
+	{"ConversionDate", "Date, conversion"},
+	{"CaptureDate", "Date, capture"},
+	{"MerchantType", "Merchant type"},
+	{"AcquiringCountryCode", "Acquiring institution country code"},
+	{"PANCountryCode", "PAN extended, country code"},
+	{"ForwardingCountryCode", "Forwarding institution country code"},
+	{"POSEntryMode", "Point of service entry mode"},
+	{"CardSequenceNumber", "Card sequence number"},
+	{"NII", "Network international identifier"},
+	{"POSConditionCode", "Point of service condition code"},
+	{"POSPINCaptureCode", "Point of service PIN capture code"},
+	{"AuthIDResponseLength", "Authorization identification response length"},
+	{"TransactionFee", "Amount, transaction fee"},
+	{"SettlementFee", "Amount, settlement fee"},
+	{"TransactionProcessingFee", "Amount, transaction processing fee"},
+	{"SettlementProcessingFee", "Amount, settlement processing fee"},
+	{"AcquiringInstitutionID", "Acquiring institution identification code"},
+	{"ForwardingInstitutionID", "Forwarding institution identification code"},
+	{"ExtendedPAN", "Primary account number, extended"},
+	{"Track2", "Track 2 data"},
+	{"Track3", "Track 3 data"},
+	{"RRN", "Retrieval reference number"},
+	{"AuthIDResponse", "Authorization identification response"},
+	{"ResponseCode", "Response code"},
+	{"ServiceRestrictionCode", "Service restriction code"},
+	{"TerminalID", "Card acceptor terminal identification"},
+	{"MerchantID", "Card acceptor identification code"},
+	{"CardAcceptorNameLocation", "Card acceptor name/location"},
+	{"AdditionalResponseData", "Additional response data"},
+	{"Track1", "Track 1 data"},
+	{"AdditionalDataISO", "Additional data - ISO"},
+	{"AdditionalDataNational", "Additional data - national"},
+	{"AdditionalDataPrivate", "Additional data - private"},
+	{"TransactionCurrencyCode", "Currency code, transaction"},
+	{"SettlementCurrencyCode", "Currency code, settlement"},
+	{"BillingCurrencyCode", "Currency code, cardholder billing"},
+	{"PINData", "Personal identification number data"},
+	{"SecurityControlInfo", "Security related control information"},
+	{"AdditionalAmounts", "Additional amounts"},
+	{"ICCData", "Integrated circuit card system related data"},
+	{"ReservedISO56", "Reserved ISO"},
+	{"ReservedNational57", "Reserved national"},
+	{"ReservedNational58", "Reserved national"},
+	{"ReservedNational59", "Reserved national"},
+	{"ReservedPrivate60", "Reserved private"},
+	{"ReservedPrivate61", "Reserved private"},
+	{"ReservedPrivate62", "Reserved private"},
+	{"ReservedPrivate63", "Reserved private"},
+	{"MAC", "Message authentication code"},
+	{"ExtendedBitmap", "Bitmap, extended"},
+	{"SettlementCode", "Settlement code"},
+	{"ExtendedPaymentCode", "Extended payment code"},
+	{"ReceivingCountryCode", "Receiving institution country code"},
+	{"SettlementCountryCode", "Settlement institution country code"},
+	{"NetworkMgmtCode", "Network management information code"},
+	{"MessageNumber", "Message number"},
+	{"MessageNumberLast", "Message number, last"},
+	{"ActionDate", "Date, action"},
+	{"CreditsNumber", "Credits, number"},
+	{"CreditsReversalNumber", "Credits, reversal number"},
+	{"DebitsNumber", "Debits, number"},
+	{"DebitsReversalNumber", "Debits, reversal number"},
+	{"TransferNumber", "Transfer, number"},
+	{"TransferReversalNumber", "Transfer, reversal number"},
+	{"InquiriesNumber", "Inquiries, number"},
+	{"AuthorizationsNumber", "Authorizations, number"},
+	{"CreditsProcessingFee", "Credits, processing fee amount"},
+	{"CreditsTransactionFee", "Credits, transaction fee amount"},
+	{"DebitsProcessingFee", "Debits, processing fee amount"},
+	{"DebitsTransactionFee", "Debits, transaction fee amount"},
+	{"CreditsAmount", "Credits, amount"},
+	{"CreditsReversalAmount", "Credits, reversal amount"},
+	{"DebitsAmount", "Debits, amount"},
+	{"DebitsReversalAmount", "Debits, reversal amount"},
+	{"OriginalDataElements", "Original data elements"},
+	{"FileUpdateCode", "File update code"},
+	{"FileSecurityCode", "File security code"},
+	{"ResponseIndicator", "Response indicator"},
+	{"ServiceIndicator", "Service indicator"},
+	{"ReplacementAmounts", "Replacement amounts"},
+	{"MessageSecurityCode", "Message security code"},
+	{"NetSettlementAmount", "Amount, net settlement"},
+	{"Payee", "Payee"},
+	{"SettlementInstitutionID", "Settlement institution identification code"},
+	{"ReceivingInstitutionID", "Receiving institution identification code"},
+	{"FileName", "File name"},
+	{"AccountID1", "Account identification 1"},
+	{"AccountID2", "Account identification 2"},
+	{"TransactionDescription", "Transaction description"},
+	{"ReservedISO105", "Reserved ISO"},
+	{"ReservedISO106", "Reserved ISO"},
+	{"ReservedISO107", "Reserved ISO"},
+	{"ReservedISO108", "Reserved ISO"},
+	{"ReservedISO109", "Reserved ISO"},
+	{"ReservedISO110", "Reserved ISO"},
+	{"ReservedISO111", "Reserved ISO"},
+	{"ReservedNational112", "Reserved national"},
+	{"ReservedNational113", "Reserved national"},
+	{"ReservedNational114", "Reserved national"},
+	{"ReservedNational115", "Reserved national"},
+	{"ReservedNational116", "Reserved national"},
+	{"ReservedNational117", "Reserved national"},
+	{"ReservedNational118", "Reserved national"},
+	{"ReservedNational119", "Reserved national"},
+	{"ReservedPrivate120", "Reserved private"},
+	{"ReservedPrivate121", "Reserved private"},
+	{"ReservedPrivate122", "Reserved private"},
+	{"ReservedPrivate123", "Reserved private"},
+	{"ReservedPrivate124", "Reserved private"},
+	{"ReservedPrivate125", "Reserved private"},
+	{"ReservedPrivate126", "Reserved private"},
+	{"ReservedPrivate127", "Reserved private"},
+	{"MAC2", "Message authentication code"},
+}
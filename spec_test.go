@@ -0,0 +1,190 @@
+package iso8583
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpecExport(t *testing.T) {
+	spec := SpecFromIsoExDef("ScUnion", ASCTYPE, BCDTYPE, ASCTYPE, IsoExDefScUnion)
+	if spec.Fields[1].Name != "PAN" || spec.Fields[40].Name != "TerminalID" {
+		t.Fatalf("names %s %s", spec.Fields[1].Name, spec.Fields[40].Name)
+	}
+
+	var js, ym bytes.Buffer
+	if err := WriteSpecJSON(&js, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSpecYAML(&ym, spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []*bytes.Buffer{&js, &ym} {
+		spec2, err := ParseSpec(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec2.Name != spec.Name || spec2.MsgType != spec.MsgType || spec2.BitType != spec.BitType || spec2.LenType != spec.LenType {
+			t.Fatalf("spec header %+v", spec2)
+		}
+		if !reflect.DeepEqual(spec2.Fields[1:], spec.Fields[1:]) {
+			t.Fatal("spec fields differ after export")
+		}
+
+		/*首采联合报文用导出的规范解包打包*/
+		data := []byte("0200" + "\x20\x00\x00\x00\x00\x80\x00\x00" + "000000" + "TERM0001")
+		iso, err := NewIsoExSpec(spec2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = iso.Str2IsoEx(data); err != nil {
+			t.Fatal(err)
+		}
+		data2, _ := iso.Iso2StrEx()
+		if bytes.Compare(data, data2) != 0 {
+			t.Fatal("Compare data and data2 failed")
+		}
+	}
+}
+
+const testSpecYAML = `# test acquirer
+name: "Test Host"
+mti: ascii
+bitmap: hex
+length: ascii
+codepage: 1047
+fields:
+- id: 2
+  name: PAN
+  max_length: 19
+  length_type: LLVAR
+  encoding: ascii
+  padding: 0           # unquoted
+  justify: left
+- id: 4
+  name: Amount
+  description: 'Amount, transaction'
+  max_length: 12
+  length_type: fixed
+  encoding: ascii
+  padding: "0"
+  justify: right
+-
+  id: 48
+  max_length: 999
+  length_type: LLLVAR
+  length_encoding: binary
+  encoding: ebcdic
+  padding: " "
+  justify: left
+- id: 64
+  max_length: 8
+  length_type: fixed
+  encoding: binary
+  padding: "0x00"
+  justify: left
+  length_in_bytes: true
+`
+
+func TestParseSpecYAML(t *testing.T) {
+	spec, err := ParseSpec(strings.NewReader(testSpecYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "Test Host" || spec.BitType != ASCTYPE || spec.CodePage != CP1047 || len(spec.Fields) != 64 {
+		t.Fatalf("spec %+v", spec)
+	}
+	if spec.Fields[2].defined() || spec.Fields[3].Desc != "Amount, transaction" || !spec.Fields[3].RightJust {
+		t.Fatalf("field 4 %+v", spec.Fields[3])
+	}
+	if spec.Fields[47].LenEnc != BINTYPE || spec.Fields[47].DataEnc != ISODEBC || spec.Fields[63].Pad != 0 {
+		t.Fatalf("field 48 %+v", spec.Fields[47])
+	}
+
+	iso, err := NewIsoExSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso.SetMTI("0100")
+	iso.SetField(2, []byte("6222020000001234"))
+	iso.SetField(4, []byte("100"))
+	iso.SetField(48, []byte("[x]"))
+	iso.SetField(64, []byte{1, 2, 3, 4})
+	if err = iso.SetField(3, []byte("000000")); err == nil {
+		t.Fatal("SetField accept undefined field")
+	}
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "0100" + "5000000000010001" + "166222020000001234" + "000000000100" + "\x00\x03\xad\xa7\xbd" + "\x01\x02\x03\x04\x00\x00\x00\x00"
+	if string(data) != expect {
+		t.Fatalf("Iso2StrEx [% x]", data)
+	}
+
+	/*位图中出现未定义的域*/
+	err = iso.Str2IsoEx([]byte("01002000000000000000000000"))
+	if perr, ok := err.(*ParseError); !ok || perr.Field != 3 || perr.Err != ErrNoFieldDef {
+		t.Fatalf("undefined field: %v", err)
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	spec := SpecFromIsoExDef("YL", BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	var js bytes.Buffer
+	WriteSpecJSON(&js, spec)
+	os.WriteFile(filepath.Join(dir, "yl.json"), js.Bytes(), 0644)
+	os.WriteFile(filepath.Join(dir, "test.yml"), []byte(testSpecYAML), 0644)
+
+	if _, err := LoadSpec(filepath.Join(dir, "yl.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSpec(filepath.Join(dir, "test.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []string{
+		`{"mti": "bcd", "bitmap": "binary", "length": "bcd", "fields": [{"id": 2, "max_length": 19, "length_type": "LVAR", "encoding": "bcd"}]}`,
+		`{"mti": "packed", "bitmap": "binary", "length": "bcd", "fields": []}`,
+		`{"mti": "bcd", "bitmap": "binary", "length": "bcd", "fieldz": []}`,
+		"mti: bcd\nbitmap: binary\nlength: bcd\nfields:\n  - id: 2\n    max_length: 19\n   length_type: fixed\n",
+		"mti: bcd\nbitmap: binary\nlength: bcd\nfields:\n  - id: 2\n    max_length: 19\n    length_type: fixed\n    encoding: bcd\n  - id: 2\n    max_length: 19\n    length_type: fixed\n    encoding: bcd\n",
+	}
+	for _, b := range bad {
+		if _, err := ParseSpec(strings.NewReader(b)); err == nil {
+			t.Fatalf("ParseSpec accept %s", b)
+		}
+	}
+}
+
+func TestParseSpecYAMLUnsupported(t *testing.T) {
+	head := "mti: bcd\nbitmap: binary\nlength: bcd\n"
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{head + "fields: [{id: 2, max_length: 19}]\n", "line 4: flow style"},
+		{head + "fields:\n  - {id: 2, max_length: 19}\n", "line 5: flow style"},
+		{"name: &host YL\n" + head, "line 1: anchors and aliases"},
+		{head + "name: *host\n", "line 4: anchors and aliases"},
+		{head + "name: !!str YL\n", "line 4: tags"},
+		{head + "name: |\n  Test\n  Host\n", "line 4: multi-line strings"},
+		{head + "name: >-\n  Test\n", "line 4: multi-line strings"},
+		{head + "name: \"Test\n  Host\"\n", "line 4: multi-line strings"},
+		{head + "name: Test\n  Host\n", "line 4: multi-line strings"},
+		{head + "---\nname: YL\n", "line 4: multiple documents"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSpec(strings.NewReader(tt.yaml))
+		if err == nil || !strings.Contains(err.Error(), tt.want+" not supported") {
+			t.Errorf("%q: %v, want %s", tt.yaml, err, tt.want)
+		}
+	}
+	if _, err := ParseSpec(strings.NewReader("---\n" + testSpecYAML + "...\n")); err != nil {
+		t.Errorf("document markers: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package iso8583
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/*报文长度头(帧)格式*/
+const FRAME_NONE = 0 /*无长度头,读到EOF为一个报文*/
+const FRAME_BIN2 = 1 /*2字节二进制,高位在前*/
+const FRAME_ASC4 = 2 /*4位ASCII数字*/
+const FRAME_BCD2 = 3 /*2字节BCD,4位数字*/
+
+const MAX_FRAME_LEN = 0xFFFF
+
+var ErrFrameTooLong = errors.New("frame too long")
+
+func frameHeadLen(framing int16) int {
+	switch framing {
+	case FRAME_BIN2, FRAME_BCD2:
+		return 2
+	case FRAME_ASC4:
+		return 4
+	}
+	return 0
+}
+
+func frameMaxLen(framing int16) int {
+	switch framing {
+	case FRAME_ASC4, FRAME_BCD2:
+		return 9999
+	}
+	return MAX_FRAME_LEN
+}
+
+/*
+Decoder 从io.Reader读取带长度头的报文,每次Decode按proto的域定义与配置
+(包括报文头长度)解出一个新的IsoEx.长度为0的帧(心跳)被跳过.
+*/
+type Decoder struct {
+	r       *bufio.Reader
+	proto   *IsoEx
+	framing int16
+	maxlen  int
+}
+
+func NewDecoder(r io.Reader, proto *IsoEx, framing int16) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), proto: proto, framing: framing, maxlen: frameMaxLen(framing)}
+}
+
+/*报文最大长度(含报文头),超过时返回ErrFrameTooLong*/
+func (d *Decoder) SetMaxLen(n int) {
+	d.maxlen = n
+}
+
+/*读取一帧报文数据(不含长度头);没有数据时返回io.EOF*/
+func (d *Decoder) ReadFrame() ([]byte, error) {
+	if d.framing == FRAME_NONE {
+		data, err := io.ReadAll(io.LimitReader(d.r, int64(d.maxlen)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, io.EOF
+		}
+		if len(data) > d.maxlen {
+			return nil, ErrFrameTooLong
+		}
+		return data, nil
+	}
+
+	head := make([]byte, frameHeadLen(d.framing))
+	if _, err := io.ReadFull(d.r, head); err != nil {
+		return nil, err
+	}
+	var length int
+	switch d.framing {
+	case FRAME_BIN2:
+		length = int(head[0])<<8 | int(head[1])
+	case FRAME_BCD2:
+		if !isBcdDigits(head) {
+			return nil, fmt.Errorf("bad frame length % x", head)
+		}
+		length, _ = strconv.Atoi(string(Bcd2Asc(head, 4, 0)))
+	case FRAME_ASC4:
+		n, err := strconv.Atoi(string(head))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad frame length %q", head)
+		}
+		length = n
+	default:
+		return nil, fmt.Errorf("unsupported framing %d", d.framing)
+	}
+	if length > d.maxlen {
+		return nil, ErrFrameTooLong
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (d *Decoder) Decode() (*IsoEx, error) {
+	for {
+		data, err := d.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		iso := d.proto.Clone()
+		iso.Reset()
+		if err = iso.Str2IsoEx(data); err != nil {
+			return nil, err
+		}
+		return iso, nil
+	}
+}
+
+/*Encoder 把报文加上长度头写入io.Writer,每个报文一次Write*/
+type Encoder struct {
+	w       io.Writer
+	framing int16
+}
+
+func NewEncoder(w io.Writer, framing int16) *Encoder {
+	return &Encoder{w: w, framing: framing}
+}
+
+func (e *Encoder) Encode(iso *IsoEx) error {
+	data, err := iso.Iso2StrEx()
+	if err != nil {
+		return err
+	}
+	return e.WriteFrame(data)
+}
+
+func (e *Encoder) WriteFrame(data []byte) error {
+	length := len(data)
+	if length > frameMaxLen(e.framing) {
+		return ErrFrameTooLong
+	}
+	frame := make([]byte, 0, frameHeadLen(e.framing)+length)
+	switch e.framing {
+	case FRAME_NONE:
+	case FRAME_BIN2:
+		frame = append(frame, byte(length>>8), byte(length))
+	case FRAME_BCD2:
+		frame = append(frame, Asc2Bcd([]byte(fmt.Sprintf("%04d", length)), 4, 0)...)
+	case FRAME_ASC4:
+		frame = append(frame, fmt.Sprintf("%04d", length)...)
+	default:
+		return fmt.Errorf("unsupported framing %d", e.framing)
+	}
+	frame = append(frame, data...)
+	_, err := e.w.Write(frame)
+	return err
+}
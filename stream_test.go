@@ -0,0 +1,90 @@
+package iso8583
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	proto, _ := NewIsoEx(BCDTYPE, BCDTYPE, BCDTYPE, IsoExDefYL)
+	proto.SetHeaderLen(5)
+
+	msg := proto.Clone()
+	msg.SetHeader([]byte{0x60, 0x00, 0x03, 0x00, 0x00})
+	msg.SetMTI("0800")
+	msg.SetField(11, []byte("000001"))
+	msg.SetField(41, []byte("T01"))
+	body, err := msg.Iso2StrEx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(body, []byte{0x60, 0x00, 0x03, 0x00, 0x00, 0x08, 0x00}) {
+		t.Fatalf("header [% x]", body)
+	}
+
+	heads := map[int16][]byte{
+		FRAME_BIN2: {0x00, byte(len(body))},
+		FRAME_ASC4: []byte("0026"),
+		FRAME_BCD2: {0x00, 0x26},
+		FRAME_NONE: nil,
+	}
+	for framing, head := range heads {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf, framing)
+		if err = enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), append(head, body...)) {
+			t.Fatalf("framing %d: [% x]", framing, buf.Bytes())
+		}
+		if framing != FRAME_NONE {
+			enc.WriteFrame(nil)
+			enc.Encode(msg)
+		}
+
+		dec := NewDecoder(&buf, proto, framing)
+		for n := 0; ; n++ {
+			iso, err := dec.Decode()
+			if err == io.EOF {
+				if (framing == FRAME_NONE && n != 1) || (framing != FRAME_NONE && n != 2) {
+					t.Fatalf("framing %d: decoded %d messages", framing, n)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iso.MTI() != "0800" || string(iso.GetField(41)) != "T01     " || !bytes.Equal(iso.Header(), msg.Header()) {
+				t.Fatalf("framing %d: decoded %s", framing, iso)
+			}
+		}
+	}
+	if proto.MTI() != "" {
+		t.Fatal("Decode changed proto")
+	}
+
+	/*帧不完整*/
+	dec := NewDecoder(bytes.NewReader([]byte{0x00, 0x30, 0x60, 0x00}), proto, FRAME_BIN2)
+	if _, err = dec.Decode(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated frame: %v", err)
+	}
+	dec = NewDecoder(bytes.NewReader([]byte("12x4")), proto, FRAME_ASC4)
+	if _, err = dec.Decode(); err == nil {
+		t.Fatal("Decode accept bad length")
+	}
+	dec = NewDecoder(bytes.NewReader([]byte{0x01, 0x00}), proto, FRAME_BIN2)
+	dec.SetMaxLen(0xFF)
+	if _, err = dec.Decode(); err != ErrFrameTooLong {
+		t.Fatalf("max length: %v", err)
+	}
+	dec = NewDecoder(bytes.NewReader([]byte{0x00, 0x03, 0x60, 0x00, 0x03}), proto, FRAME_BIN2)
+	var perr *ParseError
+	if _, err = dec.Decode(); !errors.As(err, &perr) || perr.Field != -1 {
+		t.Fatalf("short header: %v", err)
+	}
+	if err = NewEncoder(io.Discard, FRAME_ASC4).WriteFrame(make([]byte, 10000)); err != ErrFrameTooLong {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+}
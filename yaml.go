@@ -0,0 +1,259 @@
+package iso8583
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+规范文件用到的YAML子集:块格式的映射与序列、标量(含单双引号)、注释.
+流格式([...] {...})、锚点与别名、标签、多行字符串及多个文档不支持,解析时报错.
+*/
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	docs := 0
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tab indentation", i+1)
+		}
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "---" || strings.HasPrefix(text, "--- ") {
+			if docs++; docs > 1 || len(lines) > 0 {
+				return nil, fmt.Errorf("yaml line %d: multiple documents not supported", i+1)
+			}
+			continue
+		}
+		if text == "" || text == "..." {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("yaml: empty document")
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("yaml line %d: bad indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+/*去掉不在引号内的 #注释*/
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isYAMLSeqItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml line %d: expected key: value", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("yaml line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+		if rest != "" {
+			if err := p.checkScalar(line, rest); err != nil {
+				return nil, err
+			}
+			m[key] = yamlScalar(rest)
+			continue
+		}
+		/*值为下一层的块,序列可以与键同一缩进*/
+		if p.pos < len(p.lines) && (p.lines[p.pos].indent > indent ||
+			(p.lines[p.pos].indent == indent && isYAMLSeqItem(p.lines[p.pos].text))) {
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		} else {
+			m[key] = nil
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSeqItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		item := strings.TrimLeft(line.text[1:], " ")
+		if item == "" {
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				v, err := p.parseBlock(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				seq = append(seq, v)
+			} else {
+				seq = append(seq, nil)
+			}
+			continue
+		}
+		/*以流格式等标记开头的项按标量检查并报错*/
+		if _, _, ok := splitYAMLKey(item); (ok && strings.IndexByte("[{&*!|>", item[0]) < 0) || isYAMLSeqItem(item) {
+			/*"- key: value"把本行当作缩进到item位置的映射(或序列)的第一行*/
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(item), text: item}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		p.pos++
+		if err := p.checkScalar(line, item); err != nil {
+			return nil, err
+		}
+		seq = append(seq, yamlScalar(item))
+	}
+	return seq, nil
+}
+
+/*不支持的写法报错,而不是当作普通字符串;调用时p.pos已指向下一行*/
+func (p *yamlParser) checkScalar(line yamlLine, text string) error {
+	var what string
+	switch text[0] {
+	case '[', '{':
+		what = "flow style"
+	case '&', '*':
+		what = "anchors and aliases"
+	case '!':
+		what = "tags"
+	case '|', '>':
+		what = "multi-line strings"
+	case '"', '\'':
+		if len(text) < 2 || text[len(text)-1] != text[0] {
+			what = "multi-line strings"
+		}
+	}
+	if what == "" && p.pos < len(p.lines) && p.lines[p.pos].indent > line.indent &&
+		!isYAMLSeqItem(p.lines[p.pos].text) {
+		what = "multi-line strings"
+	}
+	if what != "" {
+		return fmt.Errorf("yaml line %d: %s not supported", line.num, what)
+	}
+	return nil
+}
+
+/*拆分"key: value",key可以加引号*/
+func splitYAMLKey(text string) (string, string, bool) {
+	var key, rest string
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key = text[1 : end+1]
+		rest = text[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(text, ": ")
+		if i < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			i = len(text) - 1
+		}
+		key = strings.TrimSpace(text[:i])
+		rest = text[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+func yamlScalar(text string) interface{} {
+	switch {
+	case len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"':
+		if s, err := strconv.Unquote(text); err == nil {
+			return s
+		}
+		return text[1 : len(text)-1]
+	case len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'':
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1)
+	}
+	switch text {
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case "null", "Null", "NULL", "~":
+		return nil
+	}
+	if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return v
+	}
+	return text
+}
+
+/*需要时给标量加双引号,避免被解析成数字、布尔值或截断*/
+func yamlQuote(s string) string {
+	if s == "" || yamlScalar(s) != interface{}(s) || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return strconv.Quote(s)
+	}
+	for _, ch := range []byte(s) {
+		if ch < 0x20 || ch > 0x7e {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}